@@ -0,0 +1,118 @@
+package dcpcouchbase
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+var errAdminDisabled = errors.New("admin endpoints are disabled, couchbase.api.authToken is not set")
+
+type Stats struct {
+	Limits               couchbase.Limits `json:"limits"`
+	BufferedActions      int64            `json:"bufferedActions"`
+	BufferedByteSize     int64            `json:"bufferedByteSize"`
+	OldestUnflushedAgeMs int64            `json:"oldestUnflushedAgeMs"`
+	SinceLastFlushMs     int64            `json:"sinceLastFlushMs"`
+	Paused               bool             `json:"paused"`
+	TargetUnavailable    bool             `json:"targetUnavailable"`
+	Rebalancing          bool             `json:"rebalancing"`
+}
+
+// Pause stops writing to the target bucket and committing the checkpoint until Resume.
+func (c *connector) Pause() {
+	c.processor.Pause()
+}
+
+func (c *connector) Resume() {
+	c.processor.Resume()
+}
+
+// Flush writes the batch without waiting for `couchbase.batchTickerDuration`, it fails while paused.
+func (c *connector) Flush() error {
+	return c.processor.Flush()
+}
+
+func (c *connector) Stats() Stats {
+	lagMetric := c.processor.GetLagMetric()
+
+	return Stats{
+		Limits:               c.processor.GetLimits(),
+		BufferedActions:      lagMetric.BufferedActions,
+		BufferedByteSize:     lagMetric.BufferedByteSize,
+		OldestUnflushedAgeMs: lagMetric.OldestUnflushedAge.Milliseconds(),
+		SinceLastFlushMs:     lagMetric.SinceLastFlush.Milliseconds(),
+		Paused:               c.processor.IsPaused(),
+		TargetUnavailable:    c.processor.IsTargetUnavailable(),
+		Rebalancing:          c.processor.IsRebalancing(),
+	}
+}
+
+// SetLimits changes the batch and write rate limits set in update, a writeRateLimit of 0 removes the rate limit.
+func (c *connector) SetLimits(update couchbase.LimitsUpdate) error {
+	return c.processor.SetLimits(update)
+}
+
+// handleAdmin registers the `/admin` endpoints, every request needs the `couchbase.api.authToken` bearer token
+// and they are forbidden while no token is configured.
+func (c *connector) handleAdmin(mux *http.ServeMux) {
+	mux.HandleFunc("POST /admin/pause", c.authorize(func(w http.ResponseWriter, _ *http.Request) {
+		c.Pause()
+		writeJSON(w, http.StatusOK, c.Stats())
+	}))
+	mux.HandleFunc("POST /admin/resume", c.authorize(func(w http.ResponseWriter, _ *http.Request) {
+		c.Resume()
+		writeJSON(w, http.StatusOK, c.Stats())
+	}))
+	mux.HandleFunc("POST /admin/flush", c.authorize(func(w http.ResponseWriter, _ *http.Request) {
+		if err := c.Flush(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Stats())
+	}))
+	mux.HandleFunc("GET /admin/stats", c.authorize(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, c.Stats())
+	}))
+	if c.tap != nil {
+		mux.HandleFunc("GET /admin/tap", c.authorize(c.tap.serveTap))
+	}
+	mux.HandleFunc("PUT /admin/limits", c.authorize(func(w http.ResponseWriter, r *http.Request) {
+		var update couchbase.LimitsUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := c.SetLimits(update); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Stats())
+	}))
+}
+
+func (c *connector) authorize(next http.HandlerFunc) http.HandlerFunc {
+	expected := []byte(c.config.Couchbase.API.AuthToken)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(expected) == 0 {
+			writeError(w, http.StatusForbidden, errAdminDisabled)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
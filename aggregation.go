@@ -0,0 +1,373 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+const aggregationXattr = "aggregation"
+
+var aggregationViewName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// contribution is what a source document adds to the count and sum fields of its group.
+type contribution struct {
+	Values map[string]int64 `json:"values"`
+	Group  string           `json:"group"`
+}
+
+type aggregate struct {
+	field    string
+	function string
+	path     []string
+}
+
+type aggregationView struct {
+	collections    map[string]struct{}
+	groupBy        *keyTemplate
+	name           string
+	scopeName      string
+	collectionName string
+	aggregates     []aggregate
+}
+
+type aggregationMapper struct {
+	contributions         *writtenValues[*contribution]
+	trackerKeyPrefix      string
+	trackerScopeName      string
+	trackerCollectionName string
+	views                 []*aggregationView
+	timeout               time.Duration
+}
+
+// NewAggregationMapper maintains aggregate documents, one per view and group key, from source documents.
+//
+// count and sum are kept with sub-document counters. The contribution of every source document is stored in
+// an xattr of a tracker document, so an update moving a document to another group or a deletion subtracts the
+// previous contribution. sum only accepts integers, e.g. prices in cents.
+// min and max are updated with a CAS loop and are high and low water marks, deletions do not lower them.
+// The contributions of the last cfg.CacheSize written source documents are kept in memory to skip the tracker read,
+// the targetClient must implement couchbase.XattrTargetClient for the others.
+// The tracker and the groups are different documents, so their actions are not atomic. The processor writes
+// the actions of a document in batch order, so the tracker of a source document ends with its last contribution
+// as the counters do.
+func NewAggregationMapper(cfg config.Aggregation, timeout time.Duration) (MapperE, error) {
+	m, err := newAggregationMapper(cfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.mapContext, nil
+}
+
+func (m *aggregationMapper) mapContext(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+	aggregationMapperTrace := ctx.CreateChildTrace("AggregationMapper", map[string]interface{}{})
+	defer aggregationMapperTrace.Finish()
+
+	return m.mapEvent(ctx.TargetClient, &ctx.Event)
+}
+
+func newAggregationMapper(cfg config.Aggregation, timeout time.Duration) (*aggregationMapper, error) {
+	m := &aggregationMapper{
+		contributions:         newWrittenValues[*contribution](cfg.CacheSize),
+		trackerKeyPrefix:      cfg.TrackerKeyPrefix,
+		trackerScopeName:      cfg.TrackerScopeName,
+		trackerCollectionName: cfg.TrackerCollectionName,
+		timeout:               timeout,
+	}
+
+	names := map[string]struct{}{}
+	for idx, viewConfig := range cfg.Views {
+		view, err := compileAggregationView(viewConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couchbase.aggregation.views[%v]: %w", idx, err)
+		}
+		if _, ok := names[view.name]; ok {
+			return nil, fmt.Errorf("couchbase.aggregation.views[%v]: duplicate name %q", idx, view.name)
+		}
+		names[view.name] = struct{}{}
+		m.views = append(m.views, view)
+	}
+	return m, nil
+}
+
+func compileAggregationView(cfg config.AggregationView) (*aggregationView, error) {
+	if !aggregationViewName.MatchString(cfg.Name) {
+		return nil, fmt.Errorf("name %q must be an identifier", cfg.Name)
+	}
+
+	groupBy, err := compileKeyTemplate(cfg.GroupBy)
+	if err != nil {
+		return nil, fmt.Errorf("groupBy: %w", err)
+	}
+
+	if len(cfg.Aggregates) == 0 {
+		return nil, fmt.Errorf("aggregates are empty")
+	}
+
+	view := &aggregationView{
+		collections:    toSet(cfg.Collections),
+		groupBy:        groupBy,
+		name:           cfg.Name,
+		scopeName:      cfg.ScopeName,
+		collectionName: cfg.CollectionName,
+	}
+
+	for idx, aggregateConfig := range cfg.Aggregates {
+		if aggregateConfig.Field == "" {
+			return nil, fmt.Errorf("aggregates[%v]: field is empty", idx)
+		}
+		switch aggregateConfig.Function {
+		case config.AggregateCount:
+		case config.AggregateSum, config.AggregateMin, config.AggregateMax:
+			if aggregateConfig.Path == "" {
+				return nil, fmt.Errorf("aggregates[%v]: %v needs a path", idx, aggregateConfig.Function)
+			}
+		default:
+			return nil, fmt.Errorf("aggregates[%v]: unknown function %q", idx, aggregateConfig.Function)
+		}
+
+		view.aggregates = append(view.aggregates, aggregate{
+			field:    aggregateConfig.Field,
+			function: aggregateConfig.Function,
+			path:     splitJSONPath(aggregateConfig.Path),
+		})
+	}
+	return view, nil
+}
+
+// mapEvent decodes the value only when a view selects the collection of the event,
+// so documents of other collections may be binary.
+func (m *aggregationMapper) mapEvent(targetClient couchbase.TargetClient, e *couchbase.Event) ([]couchbase.CBActionDocument, error) {
+	trackerKey := []byte(m.trackerKeyPrefix + e.CollectionName + "::" + string(e.Key))
+
+	var doc map[string]any
+	var actions []couchbase.CBActionDocument
+	for _, view := range m.views {
+		if len(view.collections) > 0 {
+			if _, ok := view.collections[e.CollectionName]; !ok {
+				continue
+			}
+		}
+
+		if e.IsMutated && doc == nil {
+			var err error
+			if doc, err = decodeJSONObject(e.Value); err != nil {
+				return nil, err
+			}
+		}
+
+		viewActions, err := m.mapView(targetClient, view, e, doc, trackerKey)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation view %q: %w", view.name, err)
+		}
+		actions = append(actions, viewActions...)
+	}
+	return actions, nil
+}
+
+func (m *aggregationMapper) mapView(
+	targetClient couchbase.TargetClient,
+	view *aggregationView,
+	e *couchbase.Event,
+	doc map[string]any,
+	trackerKey []byte,
+) ([]couchbase.CBActionDocument, error) {
+	var current *contribution
+	var extremes []couchbase.PathValue
+	if doc != nil {
+		var err error
+		if current, extremes, err = view.contribution(e, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	cacheKey := view.name + "::" + string(trackerKey)
+	previous, err := m.previousContribution(targetClient, view, cacheKey, trackerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []couchbase.CBActionDocument
+	if !reflect.DeepEqual(previous, current) {
+		actions = view.counterActions(previous, current)
+
+		tracker, err := m.trackerAction(view, trackerKey, current)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, tracker)
+		m.contributions.track(cacheKey, current, actions)
+	}
+
+	if current != nil && len(extremes) > 0 {
+		action := couchbase.NewMinMaxAction([]byte(current.Group), extremes)
+		view.route(&action)
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// contribution returns nil when the group key can not be built from doc, so the document is not in the view.
+func (v *aggregationView) contribution(e *couchbase.Event, doc map[string]any) (*contribution, []couchbase.PathValue, error) {
+	group, err := v.groupBy.execute(e, doc)
+	if err != nil {
+		// a placeholder of groupBy is missing in doc
+		return nil, nil, nil
+	}
+
+	current := &contribution{Group: string(group), Values: map[string]int64{}}
+	var extremes []couchbase.PathValue
+	for _, a := range v.aggregates {
+		if a.function == config.AggregateCount {
+			current.Values[a.field] = 1
+			continue
+		}
+
+		value, ok := getJSONPath(doc, a.path)
+		if !ok || value == nil {
+			continue
+		}
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, nil, fmt.Errorf("%v of %s is not a number", a.function, a.field)
+		}
+
+		if a.function == config.AggregateSum {
+			integer, err := number.Int64()
+			if err != nil {
+				return nil, nil, fmt.Errorf("sum of %s is not an integer: %w", a.field, err)
+			}
+			current.Values[a.field] = integer
+			continue
+		}
+
+		op := couchbase.PathMin
+		if a.function == config.AggregateMax {
+			op = couchbase.PathMax
+		}
+		extremes = append(extremes, couchbase.PathValue{Op: op, Path: []byte(a.field), Value: []byte(number.String())})
+	}
+	return current, extremes, nil
+}
+
+// counterActions subtracts previous from its group and adds current to its group, unchanged fields are skipped.
+func (v *aggregationView) counterActions(previous *contribution, current *contribution) []couchbase.CBActionDocument {
+	deltas := map[string]map[string]int64{}
+	apply := func(c *contribution, sign int64) {
+		if c == nil {
+			return
+		}
+		if deltas[c.Group] == nil {
+			deltas[c.Group] = map[string]int64{}
+		}
+		for field, value := range c.Values {
+			deltas[c.Group][field] += sign * value
+		}
+	}
+	apply(previous, -1)
+	apply(current, 1)
+
+	var actions []couchbase.CBActionDocument
+	for _, group := range sortedKeys(deltas) {
+		var pathValues []couchbase.PathValue
+		for _, field := range sortedKeys(deltas[group]) {
+			if delta := deltas[group][field]; delta != 0 {
+				pathValues = append(pathValues, couchbase.PathValue{
+					Op:            couchbase.PathCounter,
+					Path:          []byte(field),
+					Value:         []byte(strconv.FormatInt(delta, 10)),
+					CreateParents: true,
+				})
+			}
+		}
+		if len(pathValues) == 0 {
+			continue
+		}
+
+		action := couchbase.NewMultiMutateInAction([]byte(group), pathValues)
+		v.route(&action)
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+func (v *aggregationView) route(action *couchbase.CBActionDocument) {
+	action.SetScopeName(v.scopeName)
+	action.SetCollectionName(v.collectionName)
+}
+
+func (m *aggregationMapper) trackerAction(
+	view *aggregationView, trackerKey []byte, current *contribution,
+) (couchbase.CBActionDocument, error) {
+	value, err := jsonNumber.Marshal(current)
+	if err != nil {
+		return couchbase.CBActionDocument{}, err
+	}
+
+	action := couchbase.NewMultiMutateInAction(trackerKey, []couchbase.PathValue{{
+		Path:          []byte(aggregationXattr + "." + view.name),
+		Value:         value,
+		CreateParents: true,
+		Xattr:         true,
+	}})
+	action.SetScopeName(m.trackerScopeName)
+	action.SetCollectionName(m.trackerCollectionName)
+	return action, nil
+}
+
+// previousContribution prefers the contribution of the last write, since it may still be in the batch.
+// A contribution read from the tracker is remembered as written.
+func (m *aggregationMapper) previousContribution(
+	targetClient couchbase.TargetClient, view *aggregationView, cacheKey string, trackerKey []byte,
+) (*contribution, error) {
+	if previous, ok := m.contributions.get(cacheKey); ok {
+		return previous, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	result, err := couchbase.GetXattr(ctx, targetClient,
+		m.trackerScopeName, m.trackerCollectionName, trackerKey, aggregationXattr+"."+view.name)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		m.contributions.store(cacheKey, nil)
+		return nil, nil
+	}
+
+	var previous *contribution
+	if err := jsonNumber.Unmarshal(result.Value, &previous); err != nil {
+		return nil, err
+	}
+	m.contributions.store(cacheKey, previous)
+	return previous, nil
+}
+
+func (m *aggregationMapper) reset() {
+	m.contributions.reset()
+}
+
+// ComposeMappers runs every mapper for an event and concatenates their actions.
+func ComposeMappers(mappers ...MapperE) MapperE {
+	return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		var actions []couchbase.CBActionDocument
+		for _, mapper := range mappers {
+			mapped, err := mapper(ctx)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, mapped...)
+		}
+		return actions, nil
+	}
+}
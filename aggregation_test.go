@@ -0,0 +1,116 @@
+package dcpcouchbase
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+var testAggregation = config.Aggregation{
+	TrackerKeyPrefix: "tracker::",
+	CacheSize:        10,
+	Views: []config.AggregationView{{
+		Name:        "sellers",
+		GroupBy:     "seller::{value.sellerId}",
+		Collections: []string{"orders"},
+		Aggregates:  []config.Aggregate{{Field: "count", Function: config.AggregateCount}},
+	}},
+}
+
+// formatActions formats the key, type and the path values of every action, one action per line.
+func formatActions(actions []couchbase.CBActionDocument) string {
+	lines := make([]string, 0, len(actions))
+	for _, action := range actions {
+		line := fmt.Sprintf("%s %s", action.Type, action.ID)
+		for _, pv := range action.PathValues {
+			line += fmt.Sprintf(" %s=%s", pv.Path, pv.Value)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func newTestAggregationMapper(t *testing.T) *aggregationMapper {
+	t.Helper()
+
+	m, err := newAggregationMapper(testAggregation, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the document was never aggregated, so the tracker is not read
+	m.contributions.store("sellers::tracker::orders::o1", nil)
+	return m
+}
+
+func TestAggregationMapper_SkipsValuesOfOtherCollections(t *testing.T) {
+	m := newTestAggregationMapper(t)
+
+	e := newTapEvent("blob", "blobs", "\x00binary")
+	actions, err := m.mapEvent(nil, &e)
+	if err != nil || actions != nil {
+		t.Fatalf("expected an event of another collection to be skipped, got %v %v", actions, err)
+	}
+
+	e = newTapEvent("o1", "orders", "\x00binary")
+	if _, err = m.mapEvent(nil, &e); err == nil {
+		t.Fatal("expected a binary value of an aggregated collection to be an error")
+	}
+}
+
+func TestAggregationMapper_MovesContribution(t *testing.T) {
+	m := newTestAggregationMapper(t)
+
+	first := newTapEvent("o1", "orders", `{"sellerId":1}`)
+	actions, err := m.mapEvent(nil, &first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "MultiMutateIn seller::1 count=1\n" +
+		`MultiMutateIn tracker::orders::o1 aggregation.sellers={"values":{"count":1},"group":"seller::1"}`
+	if got := formatActions(actions); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	// the first write is still pending, the second event subtracts its contribution
+	second := newTapEvent("o1", "orders", `{"sellerId":2}`)
+	if actions, err = m.mapEvent(nil, &second); err != nil {
+		t.Fatal(err)
+	}
+	want = "MultiMutateIn seller::1 count=-1\nMultiMutateIn seller::2 count=1\n" +
+		`MultiMutateIn tracker::orders::o1 aggregation.sellers={"values":{"count":1},"group":"seller::2"}`
+	if got := formatActions(actions); got != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestConnector_MiddlewaresDoNotRewriteAggregation(t *testing.T) {
+	c := &connector{config: &config.Config{Couchbase: config.Couchbase{Aggregation: testAggregation}}}
+	builder := NewConnectorBuilder(nil).
+		SetMapper(func(ctx couchbase.EventContext) []couchbase.CBActionDocument {
+			return []couchbase.CBActionDocument{couchbase.NewSetAction(ctx.Key, ctx.Value)}
+		}).
+		Use(KeyPrefixMiddleware("mirror::"))
+
+	if _, err := c.buildMapper(builder); err != nil {
+		t.Fatal(err)
+	}
+	c.mapperStates[0].(*aggregationMapper).contributions.store("sellers::tracker::orders::o1", nil)
+
+	actions, err := c.mapper(couchbase.EventContext{
+		Event:         newTapEvent("o1", "orders", `{"sellerId":1}`),
+		ListenerTrace: noopListenerTrace{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, action := range actions {
+		keys = append(keys, string(action.ID))
+	}
+	if got := strings.Join(keys, " "); got != "mirror::o1 seller::1 tracker::orders::o1" {
+		t.Fatalf("expected only the mapper action to be prefixed, got %v", got)
+	}
+}
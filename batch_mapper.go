@@ -0,0 +1,134 @@
+package dcpcouchbase
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Trendyol/go-dcp/models"
+	"github.com/Trendyol/go-dcp/tracing"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+// BatchMapper maps many events at once, the actions at index i of the result belong to ctxs[i].
+type BatchMapper func(ctxs []couchbase.EventContext) ([][]couchbase.CBActionDocument, error)
+
+type pendingEvent struct {
+	ctx           *models.ListenerContext
+	listenerTrace tracing.ListenerTrace
+	event         couchbase.Event
+}
+
+type mapperBatcher struct {
+	connector      *connector
+	mapper         BatchMapper
+	ticker         *time.Ticker
+	events         []pendingEvent
+	sizeLimit      int
+	tickerDuration time.Duration
+	generation     atomic.Uint64
+	mu             sync.Mutex
+	flushMu        sync.Mutex
+}
+
+func newMapperBatcher(connector *connector, mapper BatchMapper) *mapperBatcher {
+	cfg := connector.config.Couchbase.BatchMapper
+	return &mapperBatcher{
+		connector:      connector,
+		mapper:         mapper,
+		sizeLimit:      cfg.SizeLimit,
+		tickerDuration: cfg.TickerDuration,
+		ticker:         time.NewTicker(cfg.TickerDuration),
+		events:         make([]pendingEvent, 0, cfg.SizeLimit),
+	}
+}
+
+func (m *mapperBatcher) start() {
+	for range m.ticker.C {
+		m.flush()
+	}
+}
+
+func (m *mapperBatcher) close() {
+	m.ticker.Stop()
+	m.flush()
+}
+
+// reset drops collected events, they are not acked so dcp streams them again after rebalance.
+// Events of a flush which is already running are not acked either.
+func (m *mapperBatcher) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.generation.Add(1)
+	for _, pending := range m.events {
+		pending.listenerTrace.Finish()
+	}
+	m.events = m.events[:0]
+}
+
+func (m *mapperBatcher) add(ctx *models.ListenerContext, listenerTrace tracing.ListenerTrace, e couchbase.Event) {
+	m.mu.Lock()
+	m.events = append(m.events, pendingEvent{ctx: ctx, listenerTrace: listenerTrace, event: e})
+	isFull := len(m.events) >= m.sizeLimit
+	m.mu.Unlock()
+
+	if isFull {
+		m.flush()
+		m.ticker.Reset(m.tickerDuration)
+	}
+}
+
+// take returns the collected events and empties the batch.
+func (m *mapperBatcher) take() ([]pendingEvent, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.events
+	m.events = make([]pendingEvent, 0, m.sizeLimit)
+	return events, m.generation.Load()
+}
+
+// flush maps and enqueues the collected events in arrival order. Flushes are serialized by flushMu
+// so events of a vbucket are never acked out of order, and m.mu is not held while the processor
+// blocks so a rebalance can still reset the batcher.
+func (m *mapperBatcher) flush() {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	events, generation := m.take()
+	if len(events) == 0 {
+		return
+	}
+
+	ctxs := make([]couchbase.EventContext, len(events))
+	for i, pending := range events {
+		ctxs[i] = couchbase.EventContext{
+			TargetClient:  m.connector.targetClient,
+			Event:         pending.event,
+			ListenerTrace: pending.listenerTrace,
+		}
+	}
+
+	beforeMapperTime := time.Now()
+	results, err := m.mapper(ctxs)
+	m.connector.observeMapperLatency(beforeMapperTime)
+
+	if err == nil && len(results) != len(events) {
+		err = fmt.Errorf("batch mapper returned %v results for %v events", len(results), len(events))
+	}
+
+	for i, pending := range events {
+		switch {
+		case m.connector.isStopped.Load() || m.generation.Load() != generation:
+			// the events after a stopping error or a rebalance are not acked
+		case err != nil:
+			if m.connector.handleMapperError(pending.event, err) {
+				pending.ctx.Ack()
+			}
+		default:
+			m.connector.addActions(pending.ctx, pending.listenerTrace, pending.event, results[i])
+		}
+		pending.listenerTrace.Finish()
+	}
+}
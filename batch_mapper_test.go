@@ -0,0 +1,61 @@
+package dcpcouchbase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Trendyol/go-dcp/models"
+	"github.com/Trendyol/go-dcp/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type noopListenerTrace struct{}
+
+func (noopListenerTrace) CreateChildTrace(string, map[string]interface{}) tracing.ListenerTrace {
+	return noopListenerTrace{}
+}
+
+func (noopListenerTrace) Finish() {}
+
+func TestMapperBatcher_ResetDuringFlush(t *testing.T) {
+	c := newTestConnector(config.MapperErrorPolicyStop)
+	c.mapperLatency = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "mapper_latency"})
+
+	mapping := make(chan struct{})
+	release := make(chan struct{})
+	m := &mapperBatcher{connector: c, sizeLimit: 1, tickerDuration: time.Hour, ticker: time.NewTicker(time.Hour)}
+	m.mapper = func(ctxs []couchbase.EventContext) ([][]couchbase.CBActionDocument, error) {
+		close(mapping)
+		<-release
+		return make([][]couchbase.CBActionDocument, len(ctxs)), nil
+	}
+
+	acked := false
+	ctx := &models.ListenerContext{Ack: func() { acked = true }}
+	done := make(chan struct{})
+	go func() {
+		m.add(ctx, noopListenerTrace{}, newTapEvent("k", "orders", `{}`))
+		close(done)
+	}()
+	<-mapping
+
+	reset := make(chan struct{})
+	go func() {
+		m.reset()
+		close(reset)
+	}()
+	select {
+	case <-reset:
+	case <-time.After(time.Second):
+		t.Fatal("expected reset not to wait for the running flush")
+	}
+
+	close(release)
+	<-done
+	if acked {
+		t.Fatal("expected the event of a flush running during a reset not to be acked")
+	}
+}
@@ -0,0 +1,49 @@
+package dcpcouchbase
+
+import (
+	"fmt"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	"github.com/Trendyol/go-dcp-couchbase/expression"
+)
+
+type collectionRoute struct {
+	when           *expression.Expression
+	scopeName      string
+	collectionName string
+}
+
+func compileCollectionRoutes(routes []config.ExpressionRoute) ([]collectionRoute, error) {
+	compiled := make([]collectionRoute, 0, len(routes))
+	for idx, route := range routes {
+		when, err := expression.Compile(route.When)
+		if err != nil {
+			return nil, fmt.Errorf("couchbase.expression.routes[%v].when: %w", idx, err)
+		}
+		compiled = append(compiled, collectionRoute{
+			when:           when,
+			scopeName:      route.ScopeName,
+			collectionName: route.CollectionName,
+		})
+	}
+	return compiled, nil
+}
+
+// routeActions applies the first matching route to the actions which the mapper did not route itself.
+func (c *connector) routeActions(e *couchbase.Event, actions []couchbase.CBActionDocument) {
+	for _, route := range c.routes {
+		if !route.when.Match(e) {
+			continue
+		}
+
+		for i := range actions {
+			if actions[i].CollectionName != "" {
+				continue
+			}
+			actions[i].SetScopeName(route.scopeName)
+			actions[i].SetCollectionName(route.collectionName)
+		}
+		return
+	}
+}
@@ -1,6 +1,12 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Trendyol/go-dcp/helpers"
@@ -13,35 +19,291 @@ const (
 	DefaultCollectionName = "_default"
 )
 
+const (
+	MapperErrorPolicyStop    = "stop"
+	MapperErrorPolicySkip    = "skip"
+	MapperErrorPolicyHandler = "handler"
+)
+
+const (
+	SpoolFsyncAlways = "always"
+	SpoolFsyncBatch  = "batch"
+	SpoolFsyncNever  = "never"
+)
+
+const (
+	ShadowModeCompare = "compare"
+	ShadowModeWrite   = "write"
+)
+
+const (
+	DefaultMapperModeSet  = "set"
+	DefaultMapperModeDiff = "diff"
+)
+
+const DefaultDiffCacheSize = 100000
+
+const (
+	DeletionModeHardDelete  = "hardDelete"
+	DeletionModeMarkDeleted = "markDeleted"
+	DeletionModeArchive     = "archive"
+	DeletionModeIgnore      = "ignore"
+)
+
+type DeletionPolicy struct {
+	Mode                  string        `yaml:"mode"`
+	MarkPath              string        `yaml:"markPath"`
+	ArchiveScopeName      string        `yaml:"archiveScopeName"`
+	ArchiveCollectionName string        `yaml:"archiveCollectionName"`
+	ArchiveTTL            time.Duration `yaml:"archiveTTL"`
+}
+
+type Deletion struct {
+	// Collections overrides the policy by source collection, empty fields are taken from the top level policy.
+	Collections    map[string]DeletionPolicy `yaml:"collections"`
+	DeletionPolicy `yaml:",inline"`
+}
+
+type DefaultMapper struct {
+	Mode     string   `yaml:"mode"`
+	DiffPath string   `yaml:"diffPath"`
+	Deletion Deletion `yaml:"deletion"`
+	// DiffCacheSize is the count of recently written documents the diff mode compares against without a read.
+	DiffCacheSize int `yaml:"diffCacheSize"`
+}
+
+const (
+	AggregateCount = "count"
+	AggregateSum   = "sum"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+)
+
+type Aggregate struct {
+	Field    string `yaml:"field"`
+	Function string `yaml:"function"`
+	Path     string `yaml:"path"`
+}
+
+type AggregationView struct {
+	Name           string      `yaml:"name"`
+	GroupBy        string      `yaml:"groupBy"`
+	ScopeName      string      `yaml:"scopeName"`
+	CollectionName string      `yaml:"collectionName"`
+	Collections    []string    `yaml:"collections"`
+	Aggregates     []Aggregate `yaml:"aggregates"`
+}
+
+type Aggregation struct {
+	TrackerKeyPrefix      string            `yaml:"trackerKeyPrefix"`
+	TrackerScopeName      string            `yaml:"trackerScopeName"`
+	TrackerCollectionName string            `yaml:"trackerCollectionName"`
+	Views                 []AggregationView `yaml:"views"`
+	CacheSize             int               `yaml:"cacheSize"`
+}
+
+type FilterList struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+type EventFilter struct {
+	Collections FilterList `yaml:"collections"`
+	KeyPrefixes FilterList `yaml:"keyPrefixes"`
+	KeyPatterns FilterList `yaml:"keyPatterns"`
+	EventTypes  FilterList `yaml:"eventTypes"`
+}
+
+const (
+	HistoryVersionKeyRevNo = "revNo"
+	HistoryVersionKeySeqNo = "seqNo"
+)
+
+type HistoryRetention struct {
+	Count int           `yaml:"count"`
+	TTL   time.Duration `yaml:"ttl"`
+}
+
+type History struct {
+	ScopeName      string           `yaml:"scopeName"`
+	CollectionName string           `yaml:"collectionName"`
+	VersionKey     string           `yaml:"versionKey"`
+	Collections    []string         `yaml:"collections"`
+	Retention      HistoryRetention `yaml:"retention"`
+	// CacheSize is the count of recently written pointer documents whose versions are pruned without a read.
+	CacheSize int  `yaml:"cacheSize"`
+	Enabled   bool `yaml:"enabled"`
+}
+
+type Spool struct {
+	MaxByteSize     any           `yaml:"maxByteSize"`
+	SegmentByteSize any           `yaml:"segmentByteSize"`
+	Dir             string        `yaml:"dir"`
+	FsyncPolicy     string        `yaml:"fsyncPolicy"`
+	ReplayInterval  time.Duration `yaml:"replayInterval"`
+	Enabled         bool          `yaml:"enabled"`
+}
+
+type MapperCondition struct {
+	Equals    any    `yaml:"equals"`
+	NotEquals any    `yaml:"notEquals"`
+	Exists    *bool  `yaml:"exists"`
+	Path      string `yaml:"path"`
+	In        []any  `yaml:"in"`
+}
+
+type MapperRule struct {
+	Rename      map[string]string `yaml:"rename"`
+	Constants   map[string]any    `yaml:"constants"`
+	KeyTemplate string            `yaml:"keyTemplate"`
+	Collections []string          `yaml:"collections"`
+	Events      []string          `yaml:"events"`
+	Where       []MapperCondition `yaml:"where"`
+	Include     []string          `yaml:"include"`
+	Exclude     []string          `yaml:"exclude"`
+}
+
+type DeclarativeMapper struct {
+	Rules []MapperRule `yaml:"rules"`
+}
+
+type ExpressionRoute struct {
+	When           string `yaml:"when"`
+	ScopeName      string `yaml:"scopeName"`
+	CollectionName string `yaml:"collectionName"`
+}
+
+type Expression struct {
+	Filter string            `yaml:"filter"`
+	Routes []ExpressionRoute `yaml:"routes"`
+}
+
+type TargetClientCache struct {
+	Size              int           `yaml:"size"`
+	TTL               time.Duration `yaml:"ttl"`
+	Enabled           bool          `yaml:"enabled"`
+	InvalidateOnWrite bool          `yaml:"invalidateOnWrite"`
+}
+
+// Histogram configures the latency histograms, bucket bounds are in seconds. A nativeBucketFactor above 1
+// also exposes native histograms, the classic buckets are kept for scrapers without native histogram support.
+type Histogram struct {
+	Buckets               []float64 `yaml:"buckets"`
+	NativeBucketFactor    float64   `yaml:"nativeBucketFactor"`
+	NativeMaxBucketNumber uint32    `yaml:"nativeMaxBucketNumber"`
+}
+
+type Metric struct {
+	Histogram Histogram `yaml:"histogram"`
+}
+
+// Tap streams the mapped events and their actions on `/admin/tap`, values are redacted unless includeValues is set.
+type Tap struct {
+	MaxSubscribers int  `yaml:"maxSubscribers"`
+	BufferSize     int  `yaml:"bufferSize"`
+	Enabled        bool `yaml:"enabled"`
+	IncludeValues  bool `yaml:"includeValues"`
+}
+
+// API serves the health and admin endpoints of the connector on port. go-dcp has no hook for extra routes,
+// so they are served next to the go-dcp API on a port of their own.
+type API struct {
+	Port                 int           `yaml:"port"`
+	LivenessFlushTimeout time.Duration `yaml:"livenessFlushTimeout"`
+	PingTimeout          time.Duration `yaml:"pingTimeout"`
+	AuthToken            string        `yaml:"authToken"`
+	Tap                  Tap           `yaml:"tap"`
+	Enabled              bool          `yaml:"enabled"`
+}
+
+// DryRun records the actions instead of writing them, a suppressed checkpoint lets the real run
+// start from the same position.
+type DryRun struct {
+	Output             string `yaml:"output"`
+	Enabled            bool   `yaml:"enabled"`
+	SuppressCheckpoint bool   `yaml:"suppressCheckpoint"`
+}
+
+// Shadow configures the mapper set by `ConnectorBuilder.SetShadowMapper`, its output is compared with the primary
+// mapper and written to the shadow collection in the write mode.
+type Shadow struct {
+	Mode           string `yaml:"mode"`
+	ScopeName      string `yaml:"scopeName"`
+	CollectionName string `yaml:"collectionName"`
+	// CollectionPrefix is prepended to the collection of the shadow actions routed to a collection by the shadow mapper.
+	CollectionPrefix string  `yaml:"collectionPrefix"`
+	LogSampleRate    float64 `yaml:"logSampleRate"`
+}
+
+type BatchMapper struct {
+	SizeLimit      int           `yaml:"sizeLimit"`
+	TickerDuration time.Duration `yaml:"tickerDuration"`
+}
+
 type Couchbase struct {
-	BatchByteSizeLimit   any           `yaml:"batchByteSizeLimit"`
-	RootCAPath           string        `yaml:"rootCAPath"`
-	CollectionName       string        `yaml:"collectionName"`
-	Username             string        `yaml:"username"`
-	Password             string        `yaml:"password"`
-	BucketName           string        `yaml:"bucketName"`
-	ScopeName            string        `yaml:"scopeName"`
-	Hosts                []string      `yaml:"hosts"`
-	BatchSizeLimit       int           `yaml:"batchSizeLimit"`
-	BatchTickerDuration  time.Duration `yaml:"batchTickerDuration"`
-	WritePoolSizePerNode int           `yaml:"writePoolSizePerNode"`
-	MaxInflightRequests  int           `yaml:"maxInflightRequests"`
-	ConnectionTimeout    time.Duration `yaml:"connectionTimeout"`
-	ConnectionBufferSize uint          `yaml:"connectionBufferSize"`
-	RequestTimeout       time.Duration `yaml:"requestTimeout"`
-	SecureConnection     bool          `yaml:"secureConnection"`
+	BatchByteSizeLimit   any               `yaml:"batchByteSizeLimit"`
+	RootCAPath           string            `yaml:"rootCAPath"`
+	CollectionName       string            `yaml:"collectionName"`
+	MapperErrorPolicy    string            `yaml:"mapperErrorPolicy"`
+	Username             string            `yaml:"username"`
+	Password             string            `yaml:"password"`
+	BucketName           string            `yaml:"bucketName"`
+	ScopeName            string            `yaml:"scopeName"`
+	Hosts                []string          `yaml:"hosts"`
+	BatchSizeLimit       int               `yaml:"batchSizeLimit"`
+	BatchTickerDuration  time.Duration     `yaml:"batchTickerDuration"`
+	WritePoolSizePerNode int               `yaml:"writePoolSizePerNode"`
+	MaxInflightRequests  int               `yaml:"maxInflightRequests"`
+	ConnectionTimeout    time.Duration     `yaml:"connectionTimeout"`
+	ConnectionBufferSize uint              `yaml:"connectionBufferSize"`
+	RequestTimeout       time.Duration     `yaml:"requestTimeout"`
+	WriteRateLimit       float64           `yaml:"writeRateLimit"`
+	Spool                Spool             `yaml:"spool"`
+	BatchMapper          BatchMapper       `yaml:"batchMapper"`
+	Mapper               DeclarativeMapper `yaml:"mapper"`
+	DefaultMapper        DefaultMapper     `yaml:"defaultMapper"`
+	Aggregation          Aggregation       `yaml:"aggregation"`
+	History              History           `yaml:"history"`
+	Expression           Expression        `yaml:"expression"`
+	EventFilter          EventFilter       `yaml:"eventFilter"`
+	TargetClientCache    TargetClientCache `yaml:"targetClientCache"`
+	Metric               Metric            `yaml:"metric"`
+	API                  API               `yaml:"api"`
+	DryRun               DryRun            `yaml:"dryRun"`
+	Shadow               Shadow            `yaml:"shadow"`
+	SecureConnection     bool              `yaml:"secureConnection"`
 }
 
+// DefaultDcpAPIPort is the go-dcp API port when `api.port` is not set.
+const DefaultDcpAPIPort = 8080
+
 type Config struct {
 	Couchbase Couchbase  `yaml:"couchbase" mapstructure:"couchbase"`
 	Dcp       config.Dcp `yaml:",inline" mapstructure:",squash"`
 }
 
+// DcpAPIPort returns the port the go-dcp API is configured on.
+func (c *Config) DcpAPIPort() int {
+	if c.Dcp.API.Port == 0 {
+		return DefaultDcpAPIPort
+	}
+	return c.Dcp.API.Port
+}
+
 func (c *Config) ApplyDefaults() {
 	c.applyDefaultScopeName()
 	c.applyDefaultCollections()
 	c.applyDefaultConnectionSettings()
 	c.applyDefaultProcess()
+	c.applyDefaultSpool()
+	c.applyDefaultBatchMapper()
+	c.applyDefaultTargetClientCache()
+	c.applyDefaultDefaultMapper()
+	c.applyDefaultAggregation()
+	c.applyDefaultHistory()
+	c.applyDefaultMetric()
+	c.applyDefaultAPI()
+	c.applyDefaultShadow()
 }
 
 func (c *Config) applyDefaultCollections() {
@@ -85,4 +347,444 @@ func (c *Config) applyDefaultProcess() {
 	if c.Couchbase.RequestTimeout == 0 {
 		c.Couchbase.RequestTimeout = 1 * time.Minute
 	}
+
+	if c.Couchbase.MapperErrorPolicy == "" {
+		c.Couchbase.MapperErrorPolicy = MapperErrorPolicyStop
+	}
+}
+
+func (c *Config) applyDefaultBatchMapper() {
+	if c.Couchbase.BatchMapper.SizeLimit == 0 {
+		c.Couchbase.BatchMapper.SizeLimit = 500
+	}
+
+	if c.Couchbase.BatchMapper.TickerDuration == 0 {
+		c.Couchbase.BatchMapper.TickerDuration = 100 * time.Millisecond
+	}
+}
+
+func (c *Config) applyDefaultTargetClientCache() {
+	if c.Couchbase.TargetClientCache.Size == 0 {
+		c.Couchbase.TargetClientCache.Size = 10000
+	}
+
+	if c.Couchbase.TargetClientCache.TTL == 0 {
+		c.Couchbase.TargetClientCache.TTL = 1 * time.Minute
+	}
+}
+
+func (c *Config) applyDefaultSpool() {
+	if !c.Couchbase.Spool.Enabled {
+		return
+	}
+
+	if c.Couchbase.Spool.Dir == "" {
+		c.Couchbase.Spool.Dir = "spool"
+	}
+
+	if c.Couchbase.Spool.MaxByteSize == nil {
+		c.Couchbase.Spool.MaxByteSize = helpers.ResolveUnionIntOrStringValue("1gb")
+	}
+
+	if c.Couchbase.Spool.SegmentByteSize == nil {
+		c.Couchbase.Spool.SegmentByteSize = helpers.ResolveUnionIntOrStringValue("64mb")
+	}
+
+	if c.Couchbase.Spool.FsyncPolicy == "" {
+		c.Couchbase.Spool.FsyncPolicy = SpoolFsyncBatch
+	}
+
+	if c.Couchbase.Spool.ReplayInterval == 0 {
+		c.Couchbase.Spool.ReplayInterval = 5 * time.Second
+	}
+}
+
+func (c *Config) applyDefaultDefaultMapper() {
+	if c.Couchbase.DefaultMapper.Mode == "" {
+		c.Couchbase.DefaultMapper.Mode = DefaultMapperModeSet
+	}
+
+	if c.Couchbase.DefaultMapper.DiffCacheSize == 0 {
+		c.Couchbase.DefaultMapper.DiffCacheSize = DefaultDiffCacheSize
+	}
+
+	deletion := &c.Couchbase.DefaultMapper.Deletion
+	if deletion.Mode == "" {
+		deletion.Mode = DeletionModeHardDelete
+	}
+
+	if deletion.MarkPath == "" {
+		deletion.MarkPath = "_deleted"
+	}
+
+	for collectionName, policy := range deletion.Collections {
+		if policy.Mode == "" {
+			policy.Mode = deletion.Mode
+		}
+		if policy.MarkPath == "" {
+			policy.MarkPath = deletion.MarkPath
+		}
+		if policy.ArchiveScopeName == "" {
+			policy.ArchiveScopeName = deletion.ArchiveScopeName
+		}
+		if policy.ArchiveCollectionName == "" {
+			policy.ArchiveCollectionName = deletion.ArchiveCollectionName
+		}
+		if policy.ArchiveTTL == 0 {
+			policy.ArchiveTTL = deletion.ArchiveTTL
+		}
+		deletion.Collections[collectionName] = policy
+	}
+}
+
+func (c *Config) applyDefaultAggregation() {
+	if len(c.Couchbase.Aggregation.Views) == 0 {
+		return
+	}
+
+	if c.Couchbase.Aggregation.TrackerKeyPrefix == "" {
+		c.Couchbase.Aggregation.TrackerKeyPrefix = "_aggregation::"
+	}
+
+	if c.Couchbase.Aggregation.CacheSize == 0 {
+		c.Couchbase.Aggregation.CacheSize = 100000
+	}
+}
+
+func (c *Config) applyDefaultHistory() {
+	if c.Couchbase.History.VersionKey == "" {
+		c.Couchbase.History.VersionKey = HistoryVersionKeyRevNo
+	}
+
+	if c.Couchbase.History.CacheSize == 0 {
+		c.Couchbase.History.CacheSize = 100000
+	}
+}
+
+func (c *Config) applyDefaultMetric() {
+	histogram := &c.Couchbase.Metric.Histogram
+	if len(histogram.Buckets) == 0 {
+		histogram.Buckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+	}
+
+	if histogram.NativeBucketFactor > 1 && histogram.NativeMaxBucketNumber == 0 {
+		histogram.NativeMaxBucketNumber = 160
+	}
+}
+
+func (c *Config) applyDefaultAPI() {
+	if c.Couchbase.API.Port == 0 {
+		c.Couchbase.API.Port = 8081
+	}
+
+	if c.Couchbase.API.LivenessFlushTimeout == 0 {
+		c.Couchbase.API.LivenessFlushTimeout = 5 * time.Minute
+	}
+
+	if c.Couchbase.API.PingTimeout == 0 {
+		c.Couchbase.API.PingTimeout = 5 * time.Second
+	}
+
+	if c.Couchbase.API.Tap.MaxSubscribers == 0 {
+		c.Couchbase.API.Tap.MaxSubscribers = 4
+	}
+
+	if c.Couchbase.API.Tap.BufferSize == 0 {
+		c.Couchbase.API.Tap.BufferSize = 256
+	}
+}
+
+func (c *Config) applyDefaultShadow() {
+	if c.Couchbase.Shadow.Mode == "" {
+		c.Couchbase.Shadow.Mode = ShadowModeCompare
+	}
+
+	if c.Couchbase.Shadow.ScopeName == "" {
+		c.Couchbase.Shadow.ScopeName = c.Couchbase.ScopeName
+	}
+
+	if c.Couchbase.Shadow.CollectionPrefix == "" {
+		c.Couchbase.Shadow.CollectionPrefix = "shadow_"
+	}
+
+	if c.Couchbase.Shadow.LogSampleRate == 0 {
+		c.Couchbase.Shadow.LogSampleRate = 0.01
+	}
+}
+
+// Validate reports every invalid field of the defaulted configuration at once, each error is prefixed by its field path.
+func (c *Config) Validate() error {
+	return c.ValidateWith(Compilers{})
+}
+
+// ValidateWith runs the checks of Validate, and checks the syntax of expressions and key templates with compilers.
+func (c *Config) ValidateWith(compilers Compilers) error {
+	v := &validation{compilers: compilers}
+	c.validateConnection(v)
+	c.validateProcess(v)
+	c.validateSpool(v)
+	c.validateAPI(v)
+	c.validateShadow(v)
+	c.validateMappers(v)
+	c.validateAggregation(v)
+	c.validateHistory(v)
+	c.validateFilters(v)
+
+	for idx, bucket := range c.Couchbase.Metric.Histogram.Buckets {
+		v.check(idx > 0 && bucket <= c.Couchbase.Metric.Histogram.Buckets[idx-1], fmt.Sprintf("metric.histogram.buckets[%v]", idx),
+			"must be greater than the previous bucket, got %v", bucket)
+	}
+	return errors.Join(v.errs...)
+}
+
+type validation struct {
+	compilers Compilers
+	errs      []error
+}
+
+func (v *validation) check(failed bool, field string, format string, args ...any) {
+	if failed {
+		v.errs = append(v.errs, fmt.Errorf("couchbase.%v: %v", field, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validation) checkByteSize(field string, value any) {
+	if err := validateByteSize(value); err != nil {
+		v.check(true, field, "%v", err)
+	}
+}
+
+func (c *Config) validateConnection(v *validation) {
+	cb := &c.Couchbase
+	v.check(len(cb.Hosts) == 0, "hosts", "at least one host is required")
+	for idx, host := range cb.Hosts {
+		v.check(strings.TrimSpace(host) == "", fmt.Sprintf("hosts[%v]", idx), "must not be empty")
+	}
+	v.check(cb.BucketName == "", "bucketName", "is required")
+	v.check(cb.SecureConnection && cb.RootCAPath == "", "rootCAPath", "is required by secureConnection")
+	v.check(cb.RequestTimeout < 0, "requestTimeout", "must not be negative, got %v", cb.RequestTimeout)
+	v.check(cb.WritePoolSizePerNode < 0, "writePoolSizePerNode", "must not be negative, got %v", cb.WritePoolSizePerNode)
+	v.check(cb.MaxInflightRequests < 1, "maxInflightRequests", "must be positive, got %v", cb.MaxInflightRequests)
+	v.check(cb.MaxInflightRequests >= 1 && cb.MaxInflightRequests < cb.WritePoolSizePerNode, "maxInflightRequests",
+		"must be at least writePoolSizePerNode (%v) to use every connection, got %v", cb.WritePoolSizePerNode, cb.MaxInflightRequests)
+}
+
+func (c *Config) validateProcess(v *validation) {
+	cb := &c.Couchbase
+	v.check(cb.BatchSizeLimit < 0, "batchSizeLimit", "must not be negative, got %v", cb.BatchSizeLimit)
+	v.check(cb.BatchTickerDuration < 0, "batchTickerDuration", "must not be negative, got %v", cb.BatchTickerDuration)
+	v.checkByteSize("batchByteSizeLimit", cb.BatchByteSizeLimit)
+	v.check(cb.WriteRateLimit < 0, "writeRateLimit", "must not be negative, got %v", cb.WriteRateLimit)
+	v.check(!oneOf(cb.MapperErrorPolicy, MapperErrorPolicyStop, MapperErrorPolicySkip, MapperErrorPolicyHandler),
+		"mapperErrorPolicy", "unexpected value %q", cb.MapperErrorPolicy)
+	v.check(cb.DefaultMapper.DiffCacheSize < 0, "defaultMapper.diffCacheSize", "must not be negative, got %v",
+		cb.DefaultMapper.DiffCacheSize)
+	v.check(cb.BatchMapper.SizeLimit < 0, "batchMapper.sizeLimit", "must not be negative, got %v", cb.BatchMapper.SizeLimit)
+	v.check(cb.BatchMapper.TickerDuration < 0, "batchMapper.tickerDuration", "must not be negative, got %v",
+		cb.BatchMapper.TickerDuration)
+	v.check(cb.TargetClientCache.Size < 0, "targetClientCache.size", "must not be negative, got %v", cb.TargetClientCache.Size)
+	v.check(cb.TargetClientCache.TTL < 0, "targetClientCache.ttl", "must not be negative, got %v", cb.TargetClientCache.TTL)
+}
+
+func (c *Config) validateSpool(v *validation) {
+	spool := &c.Couchbase.Spool
+	if !spool.Enabled {
+		return
+	}
+	v.checkByteSize("spool.maxByteSize", spool.MaxByteSize)
+	v.checkByteSize("spool.segmentByteSize", spool.SegmentByteSize)
+	if validateByteSize(spool.MaxByteSize) == nil && validateByteSize(c.Couchbase.BatchByteSizeLimit) == nil {
+		maxByteSize := helpers.ResolveUnionIntOrStringValue(spool.MaxByteSize)
+		batchByteSizeLimit := helpers.ResolveUnionIntOrStringValue(c.Couchbase.BatchByteSizeLimit)
+		v.check(maxByteSize < batchByteSizeLimit, "spool.maxByteSize",
+			"must be at least batchByteSizeLimit (%v), a full batch is spooled at once, got %v", batchByteSizeLimit, maxByteSize)
+	}
+	v.check(!oneOf(spool.FsyncPolicy, SpoolFsyncAlways, SpoolFsyncBatch, SpoolFsyncNever),
+		"spool.fsyncPolicy", "unexpected value %q", spool.FsyncPolicy)
+	v.check(spool.ReplayInterval < 0, "spool.replayInterval", "must not be negative, got %v", spool.ReplayInterval)
+}
+
+func (c *Config) validateAPI(v *validation) {
+	api := &c.Couchbase.API
+	v.check(api.Port < 1 || api.Port > 65535, "api.port", "must be between 1 and 65535, got %v", api.Port)
+	v.check(api.Enabled && !c.Dcp.API.Disabled && api.Port == c.DcpAPIPort(), "api.port",
+		"must differ from the go-dcp api.port %v", api.Port)
+	v.check(api.LivenessFlushTimeout < 0, "api.livenessFlushTimeout", "must not be negative, got %v", api.LivenessFlushTimeout)
+	v.check(api.PingTimeout < 0, "api.pingTimeout", "must not be negative, got %v", api.PingTimeout)
+	v.check(api.Tap.MaxSubscribers < 0, "api.tap.maxSubscribers", "must not be negative, got %v", api.Tap.MaxSubscribers)
+	v.check(api.Tap.BufferSize < 0, "api.tap.bufferSize", "must not be negative, got %v", api.Tap.BufferSize)
+	v.check(api.Tap.Enabled && api.AuthToken == "", "api.tap.enabled", "needs api.authToken, the tap is an admin endpoint")
+}
+
+func (c *Config) validateShadow(v *validation) {
+	shadow := &c.Couchbase.Shadow
+	v.check(!oneOf(shadow.Mode, ShadowModeCompare, ShadowModeWrite), "shadow.mode", "unexpected value %q", shadow.Mode)
+	v.check(shadow.Mode == ShadowModeWrite && shadow.CollectionName == "", "shadow.collectionName",
+		"is required by the write mode")
+	v.check(shadow.LogSampleRate < 0 || shadow.LogSampleRate > 1, "shadow.logSampleRate",
+		"must be between 0 and 1, got %v", shadow.LogSampleRate)
+}
+
+// Compilers check the syntax of expressions and key templates in ValidateWith, a nil compiler skips the check.
+// The compilers depend on this package, dcpcouchbase.ValidateConfig passes them.
+type Compilers struct {
+	Expression  func(src string) error
+	KeyTemplate func(template string) (usesValues bool, err error)
+}
+
+// eventTypes are the event type names of couchbase.Event, which can not be imported here.
+var eventTypes = []string{"mutation", "deletion", "expiration"}
+
+var aggregationViewName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+func (v *validation) checkExpression(field string, src string) {
+	if v.compilers.Expression == nil {
+		return
+	}
+	if err := v.compilers.Expression(src); err != nil {
+		v.check(true, field, "%v", err)
+	}
+}
+
+// checkKeyTemplate reports whether template uses value placeholders, false when its syntax is invalid.
+func (v *validation) checkKeyTemplate(field string, template string) bool {
+	if v.compilers.KeyTemplate == nil {
+		return false
+	}
+	usesValues, err := v.compilers.KeyTemplate(template)
+	if err != nil {
+		v.check(true, field, "%v", err)
+	}
+	return usesValues
+}
+
+func (v *validation) checkEventTypes(field string, values []string) {
+	for _, value := range values {
+		v.check(!slices.Contains(eventTypes, value), field, "unknown event type %q", value)
+	}
+}
+
+func (c *Config) validateMappers(v *validation) {
+	defaultMapper := &c.Couchbase.DefaultMapper
+	v.check(!oneOf(defaultMapper.Mode, DefaultMapperModeSet, DefaultMapperModeDiff), "defaultMapper.mode",
+		"unexpected value %q", defaultMapper.Mode)
+
+	validateDeletionPolicy(v, "defaultMapper.deletion", defaultMapper.Deletion.DeletionPolicy)
+	collectionNames := slices.Sorted(maps.Keys(defaultMapper.Deletion.Collections))
+	for _, collectionName := range collectionNames {
+		validateDeletionPolicy(v, "defaultMapper.deletion.collections."+collectionName,
+			defaultMapper.Deletion.Collections[collectionName])
+	}
+
+	for idx, rule := range c.Couchbase.Mapper.Rules {
+		field := fmt.Sprintf("mapper.rules[%v]", idx)
+		v.checkEventTypes(field+".events", rule.Events)
+		if rule.KeyTemplate != "" {
+			withoutValues := len(rule.Events) == 0 || slices.ContainsFunc(rule.Events, func(event string) bool {
+				return event != "mutation"
+			})
+			v.check(v.checkKeyTemplate(field+".keyTemplate", rule.KeyTemplate) && withoutValues, field+".keyTemplate",
+				"%q uses value placeholders, rule must be limited to mutation events", rule.KeyTemplate)
+		}
+		for conditionIdx, condition := range rule.Where {
+			v.check(condition.Path == "", fmt.Sprintf("%v.where[%v].path", field, conditionIdx), "is required")
+		}
+	}
+}
+
+func validateDeletionPolicy(v *validation, field string, policy DeletionPolicy) {
+	v.check(!oneOf(policy.Mode, DeletionModeHardDelete, DeletionModeMarkDeleted, DeletionModeArchive, DeletionModeIgnore),
+		field+".mode", "unexpected value %q", policy.Mode)
+	v.check(policy.Mode == DeletionModeArchive && policy.ArchiveCollectionName == "", field+".archiveCollectionName",
+		"is required by the archive mode")
+}
+
+func (c *Config) validateAggregation(v *validation) {
+	aggregation := &c.Couchbase.Aggregation
+	v.check(aggregation.CacheSize < 0, "aggregation.cacheSize", "must not be negative, got %v", aggregation.CacheSize)
+
+	names := map[string]struct{}{}
+	for idx, view := range aggregation.Views {
+		field := fmt.Sprintf("aggregation.views[%v]", idx)
+		_, duplicate := names[view.Name]
+		names[view.Name] = struct{}{}
+		v.check(!aggregationViewName.MatchString(view.Name), field+".name", "%q must be an identifier", view.Name)
+		v.check(duplicate, field+".name", "duplicate name %q", view.Name)
+		v.checkKeyTemplate(field+".groupBy", view.GroupBy)
+		v.check(len(view.Aggregates) == 0, field+".aggregates", "must not be empty")
+
+		for aggregateIdx, aggregate := range view.Aggregates {
+			aggregateField := fmt.Sprintf("%v.aggregates[%v]", field, aggregateIdx)
+			v.check(aggregate.Field == "", aggregateField+".field", "is required")
+			v.check(!oneOf(aggregate.Function, AggregateCount, AggregateSum, AggregateMin, AggregateMax),
+				aggregateField+".function", "unexpected value %q", aggregate.Function)
+			v.check(oneOf(aggregate.Function, AggregateSum, AggregateMin, AggregateMax) && aggregate.Path == "",
+				aggregateField+".path", "is required by %v", aggregate.Function)
+		}
+	}
+}
+
+func (c *Config) validateHistory(v *validation) {
+	history := &c.Couchbase.History
+	if !history.Enabled {
+		return
+	}
+	v.check(history.CollectionName == "", "history.collectionName", "is required")
+	v.check(!oneOf(history.VersionKey, HistoryVersionKeyRevNo, HistoryVersionKeySeqNo), "history.versionKey",
+		"unexpected value %q", history.VersionKey)
+	v.check(history.VersionKey == HistoryVersionKeySeqNo && history.Retention.Count > 0, "history.retention.count",
+		"needs the revNo versionKey")
+	v.check(history.Retention.Count < 0, "history.retention.count", "must not be negative, got %v", history.Retention.Count)
+	v.check(history.CacheSize < 0, "history.cacheSize", "must not be negative, got %v", history.CacheSize)
+}
+
+func (c *Config) validateFilters(v *validation) {
+	expression := &c.Couchbase.Expression
+	if expression.Filter != "" {
+		v.checkExpression("expression.filter", expression.Filter)
+	}
+	for idx, route := range expression.Routes {
+		v.checkExpression(fmt.Sprintf("expression.routes[%v].when", idx), route.When)
+	}
+
+	filter := &c.Couchbase.EventFilter
+	v.checkEventTypes("eventFilter.eventTypes", filter.EventTypes.Include)
+	v.checkEventTypes("eventFilter.eventTypes", filter.EventTypes.Exclude)
+	for _, pattern := range slices.Concat(filter.KeyPatterns.Include, filter.KeyPatterns.Exclude) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			v.check(true, "eventFilter.keyPatterns", "%v", err)
+		}
+	}
+}
+
+// validateByteSize accepts the values helpers.ResolveUnionIntOrStringValue resolves, it panics on an unparsable size.
+func validateByteSize(value any) (err error) {
+	switch v := value.(type) {
+	case int:
+		if v <= 0 {
+			return fmt.Errorf("must be positive, got %v", v)
+		}
+		return nil
+	case uint64:
+		if v == 0 {
+			return errors.New("must be positive, got 0")
+		}
+		return nil
+	case string:
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("can not parse %q as a byte size like 10mb", v)
+			}
+		}()
+		if helpers.ResolveUnionIntOrStringValue(v) <= 0 {
+			return fmt.Errorf("must be positive, got %q", v)
+		}
+		return nil
+	default:
+		return fmt.Errorf("must be a byte count or a byte size like 10mb, got %v", value)
+	}
+}
+
+func oneOf(value string, values ...string) bool {
+	return slices.Contains(values, value)
 }
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validConfig() *Config {
+	c := &Config{
+		Couchbase: Couchbase{
+			Hosts:      []string{"localhost:8091"},
+			BucketName: "dcp-test-backup",
+		},
+	}
+	c.ApplyDefaults()
+	return c
+}
+
+var validateTests = []struct {
+	modify func(c *Config)
+	name   string
+	fields []string
+}{
+	{
+		name:   "defaults",
+		modify: func(c *Config) {},
+	},
+	{
+		name:   "missing hosts and bucket",
+		modify: func(c *Config) { c.Couchbase.Hosts = nil; c.Couchbase.BucketName = "" },
+		fields: []string{"couchbase.hosts", "couchbase.bucketName"},
+	},
+	{
+		name:   "empty host",
+		modify: func(c *Config) { c.Couchbase.Hosts = append(c.Couchbase.Hosts, " ") },
+		fields: []string{"couchbase.hosts[1]"},
+	},
+	{
+		name:   "negative batch size limit",
+		modify: func(c *Config) { c.Couchbase.BatchSizeLimit = -1 },
+		fields: []string{"couchbase.batchSizeLimit"},
+	},
+	{
+		name:   "unparsable batch byte size limit",
+		modify: func(c *Config) { c.Couchbase.BatchByteSizeLimit = "ten megabytes" },
+		fields: []string{"couchbase.batchByteSizeLimit"},
+	},
+	{
+		name:   "batch byte size limit of an unexpected type",
+		modify: func(c *Config) { c.Couchbase.BatchByteSizeLimit = 1.5 },
+		fields: []string{"couchbase.batchByteSizeLimit"},
+	},
+	{
+		name:   "batch byte size limit as a size",
+		modify: func(c *Config) { c.Couchbase.BatchByteSizeLimit = "20mb" },
+	},
+	{
+		name:   "max inflight requests lower than the write pool",
+		modify: func(c *Config) { c.Couchbase.WritePoolSizePerNode = 4; c.Couchbase.MaxInflightRequests = 2 },
+		fields: []string{"couchbase.maxInflightRequests"},
+	},
+	{
+		name:   "negative max inflight requests",
+		modify: func(c *Config) { c.Couchbase.MaxInflightRequests = -1 },
+		fields: []string{"couchbase.maxInflightRequests"},
+	},
+	{
+		name:   "secure connection without root ca",
+		modify: func(c *Config) { c.Couchbase.SecureConnection = true },
+		fields: []string{"couchbase.rootCAPath"},
+	},
+	{
+		name:   "unknown mapper error policy",
+		modify: func(c *Config) { c.Couchbase.MapperErrorPolicy = "retry" },
+		fields: []string{"couchbase.mapperErrorPolicy"},
+	},
+	{
+		name: "spool",
+		modify: func(c *Config) {
+			c.Couchbase.Spool.Enabled = true
+			c.Couchbase.Spool.MaxByteSize = -1
+			c.Couchbase.Spool.SegmentByteSize = "64mb"
+			c.Couchbase.Spool.FsyncPolicy = "sometimes"
+			c.Couchbase.Spool.ReplayInterval = -time.Second
+		},
+		fields: []string{"couchbase.spool.maxByteSize", "couchbase.spool.fsyncPolicy", "couchbase.spool.replayInterval"},
+	},
+	{
+		name: "negative batch mapper and target client cache",
+		modify: func(c *Config) {
+			c.Couchbase.BatchMapper.SizeLimit = -1
+			c.Couchbase.BatchMapper.TickerDuration = -time.Second
+			c.Couchbase.TargetClientCache.Size = -1
+			c.Couchbase.TargetClientCache.TTL = -time.Minute
+		},
+		fields: []string{
+			"couchbase.batchMapper.sizeLimit", "couchbase.batchMapper.tickerDuration",
+			"couchbase.targetClientCache.size", "couchbase.targetClientCache.ttl",
+		},
+	},
+	{
+		name: "spool smaller than a batch",
+		modify: func(c *Config) {
+			c.Couchbase.Spool.Enabled = true
+			c.ApplyDefaults()
+			c.Couchbase.Spool.MaxByteSize = 1024
+		},
+		fields: []string{"couchbase.spool.maxByteSize"},
+	},
+	{
+		name:   "unsorted histogram buckets",
+		modify: func(c *Config) { c.Couchbase.Metric.Histogram.Buckets = []float64{.1, 1, .5} },
+		fields: []string{"couchbase.metric.histogram.buckets[2]"},
+	},
+	{
+		name:   "api",
+		modify: func(c *Config) { c.Couchbase.API.Port = 70000; c.Couchbase.API.Tap.Enabled = true },
+		fields: []string{"couchbase.api.port", "couchbase.api.tap.enabled"},
+	},
+	{
+		name: "api port on the go-dcp api port",
+		modify: func(c *Config) {
+			c.Couchbase.API.Enabled = true
+			c.Couchbase.API.Port = 8080
+		},
+		fields: []string{"couchbase.api.port"},
+	},
+	{
+		name:   "shadow write mode without collection",
+		modify: func(c *Config) { c.Couchbase.Shadow.Mode = ShadowModeWrite },
+		fields: []string{"couchbase.shadow.collectionName"},
+	},
+	{
+		name:   "negative write rate limit",
+		modify: func(c *Config) { c.Couchbase.WriteRateLimit = -1 },
+		fields: []string{"couchbase.writeRateLimit"},
+	},
+	{
+		name:   "unknown default mapper mode",
+		modify: func(c *Config) { c.Couchbase.DefaultMapper.Mode = "merge" },
+		fields: []string{"couchbase.defaultMapper.mode"},
+	},
+	{
+		name: "deletion policies",
+		modify: func(c *Config) {
+			c.Couchbase.DefaultMapper.Deletion.Mode = DeletionModeArchive
+			c.Couchbase.DefaultMapper.Deletion.Collections = map[string]DeletionPolicy{
+				"users":  {Mode: "purge"},
+				"orders": {Mode: DeletionModeIgnore},
+			}
+		},
+		fields: []string{"couchbase.defaultMapper.deletion.archiveCollectionName", "couchbase.defaultMapper.deletion.collections.users.mode"},
+	},
+	{
+		name: "declarative rules",
+		modify: func(c *Config) {
+			c.Couchbase.Mapper.Rules = []MapperRule{
+				{Events: []string{"mutation"}},
+				{Events: []string{"created"}, Where: []MapperCondition{{Path: "status"}, {Equals: "active"}}},
+			}
+		},
+		fields: []string{"couchbase.mapper.rules[1].events", "couchbase.mapper.rules[1].where[1].path"},
+	},
+	{
+		name: "aggregation views",
+		modify: func(c *Config) {
+			c.Couchbase.Aggregation.Views = []AggregationView{
+				{Name: "1st"},
+				{Name: "totals", Aggregates: []Aggregate{{Function: "avg"}, {Field: "amount", Function: AggregateSum}}},
+				{Name: "totals", Aggregates: []Aggregate{{Field: "count", Function: AggregateCount}}},
+			}
+		},
+		fields: []string{
+			"couchbase.aggregation.views[0].name",
+			"couchbase.aggregation.views[0].aggregates",
+			"couchbase.aggregation.views[1].aggregates[0].field",
+			"couchbase.aggregation.views[1].aggregates[0].function",
+			"couchbase.aggregation.views[1].aggregates[1].path",
+			"couchbase.aggregation.views[2].name",
+		},
+	},
+	{
+		name: "history",
+		modify: func(c *Config) {
+			c.Couchbase.History.Enabled = true
+			c.Couchbase.History.VersionKey = HistoryVersionKeySeqNo
+			c.Couchbase.History.Retention.Count = 3
+			c.Couchbase.History.CacheSize = -1
+		},
+		fields: []string{
+			"couchbase.history.collectionName", "couchbase.history.retention.count", "couchbase.history.cacheSize",
+		},
+	},
+	{
+		name: "unknown history version key",
+		modify: func(c *Config) {
+			c.Couchbase.History = History{Enabled: true, CollectionName: "history", VersionKey: "cas"}
+		},
+		fields: []string{"couchbase.history.versionKey"},
+	},
+	{
+		name:   "disabled history is not validated",
+		modify: func(c *Config) { c.Couchbase.History.VersionKey = "cas" },
+	},
+	{
+		name: "event filter",
+		modify: func(c *Config) {
+			c.Couchbase.EventFilter.EventTypes.Exclude = []string{"created"}
+			c.Couchbase.EventFilter.KeyPatterns.Include = []string{"^order:", "("}
+		},
+		fields: []string{"couchbase.eventFilter.eventTypes", "couchbase.eventFilter.keyPatterns"},
+	},
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, tt := range validateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+
+			err := c.Validate()
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected errors of %v, got none", tt.fields)
+			}
+
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.fields) {
+				t.Fatalf("expected %v errors, got %v", len(tt.fields), err)
+			}
+			for idx, field := range tt.fields {
+				if !strings.HasPrefix(lines[idx], field+": ") {
+					t.Errorf("expected error of %v, got %v", field, lines[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateWith(t *testing.T) {
+	c := validConfig()
+	c.Couchbase.Expression.Filter = "type =="
+
+	if err := c.Validate(); err != nil {
+		t.Fatalf("expected no syntax check without compilers, got %v", err)
+	}
+
+	var compiled []string
+	err := c.ValidateWith(Compilers{Expression: func(src string) error {
+		compiled = append(compiled, src)
+		return errors.New("unexpected end")
+	}})
+	if err == nil || err.Error() != "couchbase.expression.filter: unexpected end" || len(compiled) != 1 {
+		t.Fatalf("expected the compiler error of the filter, got %v, compiled %v", err, compiled)
+	}
+}
@@ -2,9 +2,14 @@ package dcpcouchbase
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Trendyol/go-dcp/helpers"
@@ -21,44 +26,105 @@ import (
 
 	"github.com/Trendyol/go-dcp-couchbase/config"
 	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	"github.com/Trendyol/go-dcp-couchbase/expression"
 	"github.com/Trendyol/go-dcp-couchbase/metric"
 
 	dcpClientConfig "github.com/Trendyol/go-dcp/config"
 	"github.com/Trendyol/go-dcp/logger"
 	"github.com/Trendyol/go-dcp/models"
+	"github.com/Trendyol/go-dcp/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Connector interface {
-	Start()
+	// Start blocks until the connector is closed, it returns the error which stopped the connector.
+	Start() error
 	Close()
 	GetDcpClient() dcpCouchbase.Client
 	GetMapperProcessLatencyMs() int64
+	Liveness() Health
+	Readiness(ctx context.Context) Health
+	Handler() http.Handler
+	Pause()
+	Resume()
+	Flush() error
+	Stats() Stats
+	SetLimits(update couchbase.LimitsUpdate) error
 }
 
 type connector struct {
-	dcp          dcp.Dcp
-	config       *config.Config
-	mapper       Mapper
-	processor    *couchbase.Processor
-	targetClient couchbase.TargetClient
-	metric       *Metric
+	dcp                dcp.Dcp
+	config             *config.Config
+	mapper             MapperE
+	mapperBatcher      *mapperBatcher
+	mapperStates       []resettable
+	mapperErrorHandler couchbase.MapperErrorHandler
+	filter             *expression.Expression
+	eventFilter        *eventFilter
+	sourceScopeName    string
+	routes             []collectionRoute
+	processor          *couchbase.Processor
+	targetClient       couchbase.TargetClient
+	client             couchbase.Client
+	apiServer          *http.Server
+	tap                *actionTap
+	metric             *Metric
+	mapperLatency      prometheus.Histogram
+	stopErr            error
+	closeOnce          sync.Once
+	stopOnce           sync.Once
+	isStarted          atomic.Bool
+	isStopped          atomic.Bool
 }
 
 type Metric struct {
 	MapperProcessLatencyMs int64
+	MapperErrorCount       int64
 }
 
-func (c *connector) Start() {
+func (c *connector) Start() error {
+	if c.config.Couchbase.API.Enabled {
+		c.startAPI()
+	}
 	go func() {
 		<-c.dcp.WaitUntilReady()
+		c.isStarted.Store(true)
 		c.processor.StartProcessor()
 	}()
+	if c.mapperBatcher != nil {
+		go c.mapperBatcher.start()
+	}
 	c.dcp.Start()
+	return c.stopErr
 }
 
 func (c *connector) Close() {
-	c.dcp.Close()
-	c.processor.Close()
+	c.closeOnce.Do(func() {
+		c.dcp.Close()
+		if c.mapperBatcher != nil {
+			c.mapperBatcher.close()
+		}
+		c.processor.Close()
+		if c.tap != nil {
+			c.tap.close()
+		}
+		if c.apiServer != nil {
+			c.closeAPI()
+		}
+	})
+}
+
+// stop closes the connector after a fatal listener error, the events from the failed one on are not acked,
+// so the next run starts from it. Start returns err.
+func (c *connector) stop(err error) {
+	c.stopOnce.Do(func() {
+		logger.Log.Error("connector is stopping, err: %v", err)
+		c.stopErr = err
+		c.isStopped.Store(true)
+		// the listener goroutine can not wait for go-dcp to close
+		go c.Close()
+	})
 }
 
 func (c *connector) GetDcpClient() dcpCouchbase.Client {
@@ -66,52 +132,184 @@ func (c *connector) GetDcpClient() dcpCouchbase.Client {
 }
 
 func (c *connector) GetMapperProcessLatencyMs() int64 {
-	return c.metric.MapperProcessLatencyMs
+	return atomic.LoadInt64(&c.metric.MapperProcessLatencyMs)
+}
+
+// observeMapperLatency records the mapper run started at startedTime, for a batch mapper it is the whole batch.
+func (c *connector) observeMapperLatency(startedTime time.Time) {
+	latency := time.Since(startedTime)
+	atomic.StoreInt64(&c.metric.MapperProcessLatencyMs, latency.Milliseconds())
+	c.mapperLatency.Observe(latency.Seconds())
+}
+
+func (c *connector) GetMapperErrorCount() int64 {
+	return atomic.LoadInt64(&c.metric.MapperErrorCount)
+}
+
+// GetFilteredEventCounts returns the count of events dropped by each `couchbase.eventFilter` list.
+func (c *connector) GetFilteredEventCounts() map[string]int64 {
+	if c.eventFilter == nil {
+		return nil
+	}
+	return c.eventFilter.droppedCounts()
+}
+
+// handleMapperError applies `couchbase.mapperErrorPolicy`, it reports whether the event is done and can be acked.
+func (c *connector) handleMapperError(e couchbase.Event, err error) bool {
+	atomic.AddInt64(&c.metric.MapperErrorCount, 1)
+
+	switch c.config.Couchbase.MapperErrorPolicy {
+	case config.MapperErrorPolicySkip:
+		logger.Log.Debug("mapper error, event skipped, key: %s, err: %v", e.Key, err)
+	case config.MapperErrorPolicyHandler:
+		c.mapperErrorHandler.OnError(&couchbase.MapperErrorHandlerContext{
+			TargetClient: c.targetClient,
+			Event:        e,
+			Err:          err,
+		})
+	default:
+		c.stop(fmt.Errorf("error while map, key: %s: %w", e.Key, err))
+		return false
+	}
+	return true
 }
 
 func (c *connector) listener(ctx *models.ListenerContext) {
+	if c.isStopped.Load() {
+		return
+	}
 	listenerTrace := ctx.ListenerTracerComponent.InitializeListenerTrace("Listen", map[string]interface{}{})
+
+	if c.eventFilter != nil && c.eventFilter.drop(ctx) {
+		listenerTrace.Finish()
+		ctx.Ack()
+		return
+	}
+
+	e, ok, err := newEvent(ctx, c.sourceScopeName)
+	if err != nil {
+		listenerTrace.Finish()
+		c.stop(fmt.Errorf("error while decoding dcp event value: %w", err))
+		return
+	}
+	if !ok {
+		listenerTrace.Finish()
+		return
+	}
+
+	if c.filter != nil && !c.filter.Match(&e) {
+		listenerTrace.Finish()
+		ctx.Ack()
+		return
+	}
+
+	if c.mapperBatcher != nil {
+		c.mapperBatcher.add(ctx, listenerTrace, e)
+		return
+	}
 	defer listenerTrace.Finish()
 
-	var e couchbase.Event
+	beforeMapperTime := time.Now()
+
+	actions, err := c.mapper(
+		couchbase.EventContext{
+			TargetClient:  c.targetClient,
+			Event:         e,
+			ListenerTrace: listenerTrace,
+		},
+	)
+
+	c.observeMapperLatency(beforeMapperTime)
+
+	if err != nil {
+		if c.handleMapperError(e, err) {
+			ctx.Ack()
+		}
+		return
+	}
+
+	c.addActions(ctx, listenerTrace, e, actions)
+}
+
+func newEvent(ctx *models.ListenerContext, scopeName string) (couchbase.Event, bool, error) {
 	switch event := ctx.Event.(type) {
 	case models.DcpMutation:
-		e = couchbase.NewMutateEvent(
-			event.Key, event.Value,
+		value, xattrs, err := couchbase.DecodeValue(event.Value, event.Datatype)
+		if err != nil {
+			return couchbase.Event{}, false, err
+		}
+		return couchbase.NewMutateEvent(
+			event.Key, value,
 			event.CollectionName, event.EventTime, event.Cas, event.VbID, event.SeqNo, event.RevNo,
-		)
+		).WithOptions(couchbase.EventOptions{
+			Xattrs:    xattrs,
+			ScopeName: scopeName,
+			Flags:     event.Flags,
+			Expiry:    event.Expiry,
+			Datatype:  event.Datatype,
+		}), true, nil
 	case models.DcpExpiration:
-		e = couchbase.NewExpireEvent(
+		return couchbase.NewExpireEvent(
 			event.Key, nil,
 			event.CollectionName, event.EventTime, event.Cas, event.VbID, event.SeqNo, event.RevNo,
-		)
+		).WithOptions(couchbase.EventOptions{ScopeName: scopeName}), true, nil
 	case models.DcpDeletion:
-		e = couchbase.NewDeleteEvent(
+		_, xattrs, err := couchbase.DecodeValue(event.Value, event.Datatype)
+		if err != nil {
+			return couchbase.Event{}, false, err
+		}
+		return couchbase.NewDeleteEvent(
 			event.Key, nil,
 			event.CollectionName, event.EventTime, event.Cas, event.VbID, event.SeqNo, event.RevNo,
-		)
+		).WithOptions(couchbase.EventOptions{
+			Xattrs:    xattrs,
+			ScopeName: scopeName,
+			Datatype:  event.Datatype,
+		}), true, nil
 	default:
-		return
+		return couchbase.Event{}, false, nil
 	}
+}
 
-	beforeMapperTime := time.Now()
-
-	actions := c.mapper(
-		couchbase.EventContext{
-			TargetClient:  c.targetClient,
-			Event:         e,
-			ListenerTrace: listenerTrace,
-		},
-	)
+// spanContextTrace is implemented by listener traces of OpenTelemetry based go-dcp tracers.
+type spanContextTrace interface {
+	SpanContext() trace.SpanContext
+}
 
-	c.metric.MapperProcessLatencyMs = time.Since(beforeMapperTime).Milliseconds()
+// addActions adds the actions of e to the batch, traced as a couchbase.event child of the go-dcp listener trace.
+// The action spans link to it when it exposes its span context.
+func (c *connector) addActions(
+	ctx *models.ListenerContext, listenerTrace tracing.ListenerTrace, e couchbase.Event, actions []couchbase.CBActionDocument,
+) {
+	if len(actions) > 0 {
+		c.routeActions(&e, actions)
+	}
+	if c.tap != nil {
+		c.tap.publish(&e, actions)
+	}
 
 	if len(actions) == 0 {
 		ctx.Ack()
 		return
 	}
 
-	batchSizeLimit := c.config.Couchbase.BatchSizeLimit
+	eventTrace := listenerTrace.CreateChildTrace("couchbase.event", map[string]interface{}{
+		"couchbase.document.key": string(e.Key),
+		"couchbase.collection":   e.CollectionName,
+		"couchbase.vbucket":      int(e.VbID),
+		"couchbase.seq_no":       int64(e.SeqNo),
+		"couchbase.action.count": len(actions),
+	})
+	defer eventTrace.Finish()
+	if traced, ok := eventTrace.(spanContextTrace); ok {
+		spanContext := traced.SpanContext()
+		for i := range actions {
+			actions[i].SetEventSpanContext(spanContext)
+		}
+	}
+
+	// the limit may be changed at runtime, see couchbase.Processor.SetLimits
+	batchSizeLimit := c.processor.GetLimits().BatchSizeLimit
 	if len(actions) > batchSizeLimit {
 		chunks := helpers.ChunkSliceWithSize[couchbase.CBActionDocument](actions, batchSizeLimit)
 		lastChunkIndex := len(chunks) - 1
@@ -134,63 +332,261 @@ func createDcp(cfg any, listener models.Listener) (dcp.Dcp, error) {
 	}
 }
 
-func newConnector(cf any, mapper Mapper, sinkResponseHandler couchbase.SinkResponseHandler) (Connector, error) {
-	cfg, err := newConfig(cf)
+// ValidateConfig checks a defaulted cfg like config.Config.Validate, including the syntax of its expressions
+// and key templates.
+func ValidateConfig(cfg *config.Config) error {
+	return cfg.ValidateWith(config.Compilers{
+		Expression: func(src string) error {
+			_, err := expression.Compile(src)
+			return err
+		},
+		KeyTemplate: func(template string) (bool, error) {
+			t, err := compileKeyTemplate(template)
+			if err != nil {
+				return false, err
+			}
+			return t.usesValues, nil
+		},
+	})
+}
+
+func newConnector(builder *ConnectorBuilder) (Connector, error) {
+	cfg, err := newConfig(builder.config)
 	if err != nil {
 		return nil, err
 	}
 	cfg.ApplyDefaults()
+	if err = ValidateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if err = validateBuilder(builder, cfg); err != nil {
+		return nil, err
+	}
 
 	connector := &connector{
-		mapper: mapper,
-		config: cfg,
-		metric: &Metric{},
+		mapperErrorHandler: builder.mapperErrorHandler,
+		config:             cfg,
+		metric:             &Metric{},
+		mapperLatency: prometheus.NewHistogram(couchbase.NewLatencyHistogramOpts(
+			"couchbase_connector_mapper_latency",
+			"Couchbase connector latency of the mapper function execution",
+			cfg.Couchbase.Metric.Histogram,
+		)),
+		sourceScopeName: cfg.Dcp.ScopeName,
+	}
+	if connector.sourceScopeName == "" {
+		connector.sourceScopeName = config.DefaultScopeName
+	}
+	if cfg.Couchbase.API.Tap.Enabled {
+		connector.tap = newActionTap(cfg.Couchbase.API.Tap)
 	}
 
-	dcp, err := createDcp(cfg.Dcp, connector.listener)
+	mapperCollectors, err := connector.buildMapper(builder)
 	if err != nil {
+		return nil, err
+	}
+	if err = connector.compileFilters(); err != nil {
+		return nil, err
+	}
+
+	if connector.dcp, err = createDcp(cfg.Dcp, connector.listener); err != nil {
 		logger.Log.Error("dcp error: %v", err)
 		return nil, err
 	}
 
-	copyOfConfig := cfg.Couchbase
-	printConfiguration(copyOfConfig)
+	printConfiguration(cfg.Couchbase)
 
-	dcpConfig := dcp.GetConfig()
+	dcpConfig := connector.dcp.GetConfig()
 	dcpConfig.Checkpoint.Type = "manual"
 
-	connector.dcp = dcp
+	if err = connector.buildProcessor(builder); err != nil {
+		return nil, err
+	}
 
-	client := couchbase.NewClient(&cfg.Couchbase)
-	err = client.Connect()
+	if builder.batchMapper != nil {
+		connector.mapperBatcher = newMapperBatcher(connector, builder.batchMapper)
+	}
+
+	connector.dcp.SetEventHandler(
+		&DcpEventHandler{
+			isFinite:      dcpConfig.IsDcpModeFinite(),
+			processor:     connector.processor,
+			mapperBatcher: connector.mapperBatcher,
+			mapperStates:  connector.mapperStates,
+		})
+	connector.dcp.SetMetricCollectors(connector.buildMetricCollector(mapperCollectors))
+
+	return connector, nil
+}
+
+// validateBuilder rejects the builder settings which would be ignored. A BatchMapper replaces the whole per event
+// mapper chain, so none of the mapper compositions apply to it.
+func validateBuilder(builder *ConnectorBuilder, cfg *config.Config) error {
+	var errs []error
+	if cfg.Couchbase.MapperErrorPolicy == config.MapperErrorPolicyHandler && builder.mapperErrorHandler == nil {
+		errs = append(errs, fmt.Errorf("mapper error policy %q requires a mapper error handler", cfg.Couchbase.MapperErrorPolicy))
+	}
+	if builder.batchMapper == nil {
+		return errors.Join(errs...)
+	}
+
+	deletion := cfg.Couchbase.DefaultMapper.Deletion
+	ignored := []struct {
+		name string
+		set  bool
+	}{
+		{"SetMapper", builder.mapper != nil},
+		{"SetShadowMapper", builder.shadowMapper != nil},
+		{"Use middlewares", len(builder.middlewares) > 0},
+		{"couchbase.mapper.rules", len(cfg.Couchbase.Mapper.Rules) > 0},
+		{"couchbase.defaultMapper.mode diff", cfg.Couchbase.DefaultMapper.Mode == config.DefaultMapperModeDiff},
+		{"couchbase.defaultMapper.deletion", deletion.Mode != config.DeletionModeHardDelete || len(deletion.Collections) > 0},
+		{"couchbase.aggregation.views", len(cfg.Couchbase.Aggregation.Views) > 0},
+		{"couchbase.history", cfg.Couchbase.History.Enabled},
+	}
+	for _, setting := range ignored {
+		if setting.set {
+			errs = append(errs, fmt.Errorf("%v does not apply to a BatchMapper", setting.name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// buildMapper wraps the resolved and the shadow mapper with the middlewares, then composes them with the
+// aggregation and history mappers, so middlewares never rewrite their tracker, group and history documents.
+func (c *connector) buildMapper(builder *ConnectorBuilder) ([]prometheus.Collector, error) {
+	cfg := c.config
+	mapper, err := c.resolveMapper(builder.mapper)
 	if err != nil {
 		return nil, err
 	}
+	mapper = chainMiddlewares(mapper, builder.middlewares)
+
+	var collectors []prometheus.Collector
+	if builder.shadowMapper != nil {
+		shadowMapper := chainMiddlewares(builder.shadowMapper, builder.middlewares)
+		if mapper, collectors, err = newShadowMapper(mapper, shadowMapper, cfg.Couchbase.Shadow); err != nil {
+			return nil, err
+		}
+	}
 
-	connector.targetClient = couchbase.NewTargetClient(cfg, client)
+	if len(cfg.Couchbase.Aggregation.Views) > 0 {
+		aggregationMapper, err := newAggregationMapper(cfg.Couchbase.Aggregation, cfg.Couchbase.RequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.mapperStates = append(c.mapperStates, aggregationMapper)
+		mapper = ComposeMappers(mapper, aggregationMapper.mapContext)
+	}
 
-	processor, err := couchbase.NewProcessor(
-		cfg,
-		client,
-		dcp.Commit,
-		sinkResponseHandler,
-		connector.targetClient,
-	)
+	if cfg.Couchbase.History.Enabled {
+		historyMapper, err := newHistoryMapper(cfg.Couchbase.History, cfg.Couchbase.RequestTimeout)
+		if err != nil {
+			return nil, err
+		}
+		c.mapperStates = append(c.mapperStates, historyMapper)
+		mapper = ComposeMappers(mapper, historyMapper.mapContext)
+	}
+	c.mapper = mapper
+	return collectors, nil
+}
+
+func (c *connector) compileFilters() error {
+	var err error
+	if c.config.Couchbase.Expression.Filter != "" {
+		if c.filter, err = expression.Compile(c.config.Couchbase.Expression.Filter); err != nil {
+			return fmt.Errorf("couchbase.expression.filter: %w", err)
+		}
+	}
+
+	if c.eventFilter, err = newEventFilter(c.config.Couchbase.EventFilter); err != nil {
+		return err
+	}
+
+	c.routes, err = compileCollectionRoutes(c.config.Couchbase.Expression.Routes)
+	return err
+}
+
+// buildProcessor connects to the target bucket and creates the processor, a dry run records the writes instead.
+func (c *connector) buildProcessor(builder *ConnectorBuilder) error {
+	cfg := c.config
+	client := couchbase.NewClient(&cfg.Couchbase)
+	if err := client.Connect(); err != nil {
+		return err
+	}
+
+	c.client = client
+	c.targetClient = couchbase.NewTargetClient(cfg, client)
+	if cfg.Couchbase.TargetClientCache.Enabled {
+		c.targetClient = couchbase.NewCachedTargetClient(c.targetClient, &cfg.Couchbase.TargetClientCache)
+	}
+
+	var writeClient couchbase.Client = client
+	checkpointCommit := c.dcp.Commit
+	if cfg.Couchbase.DryRun.Enabled {
+		var err error
+		if writeClient, err = couchbase.NewRecorderClient(client, &cfg.Couchbase); err != nil {
+			return err
+		}
+		if cfg.Couchbase.DryRun.SuppressCheckpoint {
+			checkpointCommit = func() {}
+		}
+		logger.Log.Warn("dry run is enabled, actions are not written to the target bucket")
+	}
+
+	processor, err := couchbase.NewProcessor(cfg, writeClient, checkpointCommit, builder.sinkResponseHandler, c.targetClient)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if builder.tracerProvider != nil {
+		processor.SetTracerProvider(builder.tracerProvider)
 	}
+	c.processor = processor
+	return nil
+}
 
-	connector.processor = processor
-	connector.dcp.SetEventHandler(
-		&DcpEventHandler{
-			isFinite:  dcpConfig.IsDcpModeFinite(),
-			processor: connector.processor,
-		})
+func (c *connector) buildMetricCollector(mapperCollectors []prometheus.Collector) prometheus.Collector {
+	return metric.NewMetricCollector(
+		c.processor,
+		c.GetMapperProcessLatencyMs,
+		c.GetMapperErrorCount,
+		c.GetFilteredEventCounts,
+		append([]prometheus.Collector{c.mapperLatency}, mapperCollectors...)...,
+	)
+}
 
-	metricCollector := metric.NewMetricCollector(connector.processor, connector.GetMapperProcessLatencyMs)
-	dcp.SetMetricCollectors(metricCollector)
+// resolveMapper prefers the mapper set on the builder, then the declarative `couchbase.mapper` rules
+// and falls back to DefaultMapper, or to a diff mapper when `couchbase.defaultMapper.mode` is diff.
+// `couchbase.defaultMapper.deletion` only applies to the fallback.
+func (c *connector) resolveMapper(mapper MapperE) (MapperE, error) {
+	cfg := c.config
+	if mapper != nil {
+		return mapper, nil
+	}
 
-	return connector, nil
+	if len(cfg.Couchbase.Mapper.Rules) > 0 {
+		return NewDeclarativeMapper(cfg.Couchbase.Mapper)
+	}
+
+	switch cfg.Couchbase.DefaultMapper.Mode {
+	case config.DefaultMapperModeSet:
+		mapper = Mapper(DefaultMapper).toMapperE()
+	case config.DefaultMapperModeDiff:
+		diffMapper := newDiffMapper(cfg.Couchbase.DefaultMapper.DiffPath, cfg.Couchbase.DefaultMapper.DiffCacheSize,
+			cfg.Couchbase.RequestTimeout)
+		c.mapperStates = append(c.mapperStates, diffMapper)
+		mapper = diffMapper.mapEvent
+	default:
+		return nil, fmt.Errorf("couchbase.defaultMapper.mode: unexpected value %q", cfg.Couchbase.DefaultMapper.Mode)
+	}
+
+	deletionPolicy, err := newDeletionPolicy(cfg.Couchbase.DefaultMapper.Deletion, cfg.Couchbase.RequestTimeout,
+		func() error { return c.processor.Flush() })
+	if err != nil {
+		return nil, err
+	}
+	c.mapperStates = append(c.mapperStates, deletionPolicy)
+	return deletionPolicy.middleware(mapper), nil
 }
 
 func newConfig(cf any) (*config.Config, error) {
@@ -220,26 +616,65 @@ func newConnectorConfigFromPath(path string) (*config.Config, error) {
 }
 
 type ConnectorBuilder struct {
-	mapper              Mapper
+	mapper              MapperE
+	batchMapper         BatchMapper
+	middlewares         []Middleware
 	config              any
 	sinkResponseHandler couchbase.SinkResponseHandler
+	mapperErrorHandler  couchbase.MapperErrorHandler
+	tracerProvider      trace.TracerProvider
+	shadowMapper        MapperE
 }
 
 func NewConnectorBuilder(config any) *ConnectorBuilder {
 	return &ConnectorBuilder{
 		config:              config,
-		mapper:              DefaultMapper,
 		sinkResponseHandler: nil,
 	}
 }
 
 func (c *ConnectorBuilder) SetMapper(mapper Mapper) *ConnectorBuilder {
+	c.mapper = mapper.toMapperE()
+	return c
+}
+
+func (c *ConnectorBuilder) SetMapperE(mapper MapperE) *ConnectorBuilder {
 	c.mapper = mapper
 	return c
 }
 
+// SetShadowMapper runs mapper next to the primary mapper as configured by `couchbase.shadow`,
+// Build fails when it is combined with a BatchMapper.
+func (c *ConnectorBuilder) SetShadowMapper(mapper Mapper) *ConnectorBuilder {
+	c.shadowMapper = mapper.toMapperE()
+	return c
+}
+
+func (c *ConnectorBuilder) SetShadowMapperE(mapper MapperE) *ConnectorBuilder {
+	c.shadowMapper = mapper
+	return c
+}
+
+// Use registers middlewares around the mapper, the first registered one runs first.
+func (c *ConnectorBuilder) Use(middlewares ...Middleware) *ConnectorBuilder {
+	c.middlewares = append(c.middlewares, middlewares...)
+	return c
+}
+
+// SetBatchMapper replaces the per event mapper, events are collected up to `couchbase.batchMapper`
+// limits and mapped together. The mapper compositions do not apply to it, Build rejects them.
+func (c *ConnectorBuilder) SetBatchMapper(batchMapper BatchMapper) *ConnectorBuilder {
+	c.batchMapper = batchMapper
+	return c
+}
+
+func (c *ConnectorBuilder) SetMapperErrorHandler(mapperErrorHandler couchbase.MapperErrorHandler) *ConnectorBuilder {
+	c.mapperErrorHandler = mapperErrorHandler
+	return c
+}
+
 func (c *ConnectorBuilder) Build() (Connector, error) {
-	return newConnector(c.config, c.mapper, c.sinkResponseHandler)
+	return newConnector(c)
 }
 
 func (c *ConnectorBuilder) SetLogger(l *logrus.Logger) *ConnectorBuilder {
@@ -254,8 +689,18 @@ func (c *ConnectorBuilder) SetSinkResponseHandler(sinkResponseHandler couchbase.
 	return c
 }
 
+// SetTracerProvider exports OpenTelemetry spans of batch flushes and KV writes through tp,
+// spans are dropped when it is not set.
+func (c *ConnectorBuilder) SetTracerProvider(tp trace.TracerProvider) *ConnectorBuilder {
+	c.tracerProvider = tp
+	return c
+}
+
 func printConfiguration(config config.Couchbase) {
 	config.Password = "*****"
+	if config.API.AuthToken != "" {
+		config.API.AuthToken = "*****"
+	}
 	configJSON, _ := jsoniter.Marshal(config)
 
 	dst := &bytes.Buffer{}
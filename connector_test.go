@@ -0,0 +1,90 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type recordingMapperErrorHandler struct {
+	contexts []*couchbase.MapperErrorHandlerContext
+}
+
+func (h *recordingMapperErrorHandler) OnError(ctx *couchbase.MapperErrorHandlerContext) {
+	h.contexts = append(h.contexts, ctx)
+}
+
+func newTestConnector(policy string) *connector {
+	c := &connector{
+		config:             &config.Config{Couchbase: config.Couchbase{MapperErrorPolicy: policy}},
+		metric:             &Metric{},
+		mapperErrorHandler: &recordingMapperErrorHandler{},
+	}
+	// there is no dcp client to close
+	c.closeOnce.Do(func() {})
+	return c
+}
+
+func TestMapper_ToMapperE(t *testing.T) {
+	mapper := Mapper(func(ctx couchbase.EventContext) []couchbase.CBActionDocument {
+		return []couchbase.CBActionDocument{couchbase.NewDeleteAction(ctx.Key)}
+	})
+
+	actions, err := mapper.toMapperE()(couchbase.EventContext{Event: newTapEvent("k", "orders", `{}`)})
+	if err != nil || len(actions) != 1 || string(actions[0].ID) != "k" {
+		t.Fatalf("expected the mapper actions without an error, got %v %v", actions, err)
+	}
+}
+
+func TestConnector_HandleMapperError(t *testing.T) {
+	e := newTapEvent("k", "orders", `{}`)
+	mapperErr := errors.New("can not map")
+
+	tests := []struct {
+		policy  string
+		handled bool
+		stopped bool
+	}{
+		{policy: config.MapperErrorPolicySkip, handled: true},
+		{policy: config.MapperErrorPolicyHandler, handled: true},
+		{policy: config.MapperErrorPolicyStop, stopped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			c := newTestConnector(tt.policy)
+
+			if handled := c.handleMapperError(e, mapperErr); handled != tt.handled {
+				t.Fatalf("expected handled %v, got %v", tt.handled, handled)
+			}
+			if count := c.GetMapperErrorCount(); count != 1 {
+				t.Fatalf("expected 1 mapper error, got %v", count)
+			}
+			if c.isStopped.Load() != tt.stopped || errors.Is(c.stopErr, mapperErr) != tt.stopped {
+				t.Fatalf("expected stopped %v, got %v %v", tt.stopped, c.isStopped.Load(), c.stopErr)
+			}
+
+			handler := c.mapperErrorHandler.(*recordingMapperErrorHandler)
+			if tt.policy == config.MapperErrorPolicyHandler {
+				if len(handler.contexts) != 1 || handler.contexts[0].Err != mapperErr || string(handler.contexts[0].Event.Key) != "k" {
+					t.Fatalf("expected the handler to get the event and the error, got %v", handler.contexts)
+				}
+			} else if len(handler.contexts) != 0 {
+				t.Fatalf("expected the handler not to be called, got %v", handler.contexts)
+			}
+		})
+	}
+}
+
+func TestConnector_StopKeepsTheFirstError(t *testing.T) {
+	c := newTestConnector(config.MapperErrorPolicyStop)
+	first := errors.New("first")
+
+	c.stop(first)
+	c.stop(errors.New("second"))
+	if c.stopErr != first {
+		t.Fatalf("expected the first error, got %v", c.stopErr)
+	}
+}
@@ -0,0 +1,110 @@
+package couchbase
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
+)
+
+const (
+	ActionOutcomeSuccess = "success"
+	ActionOutcomeIgnored = "ignored"
+	ActionOutcomeError   = "error"
+	ActionOutcomeRetried = "retried"
+	ActionOutcomeSpooled = "spooled"
+)
+
+// ignoredStatuses are the status codes handled as success by panicOrGo, by metric label.
+var ignoredStatuses = map[memd.StatusCode]string{
+	memd.StatusKeyNotFound:                   "key_not_found",
+	memd.StatusSubDocPathNotFound:            "path_not_found",
+	memd.StatusSubDocBadMulti:                "bad_multi",
+	memd.StatusSubDocMultiPathFailureDeleted: "multi_path_failure_deleted",
+}
+
+// ignoredStatus returns the metric label of err when its status is handled as success for action.
+func ignoredStatus(action *CBActionDocument, err error) (string, bool) {
+	var kvErr *gocbcore.KeyValueError
+	if !errors.As(err, &kvErr) {
+		return "", false
+	}
+	if action.StrictPaths &&
+		(kvErr.StatusCode == memd.StatusSubDocPathNotFound || kvErr.StatusCode == memd.StatusSubDocBadMulti) {
+		return "", false
+	}
+	status, ok := ignoredStatuses[kvErr.StatusCode]
+	return status, ok
+}
+
+type ActionCountKey struct {
+	ActionType     CbAction
+	CollectionName string
+	Outcome        string
+}
+
+type actionCounter struct {
+	counts         map[ActionCountKey]*atomic.Int64
+	ignoredCounts  map[string]*atomic.Int64
+	collectionName string
+	mu             sync.RWMutex
+}
+
+func newActionCounter(collectionName string) *actionCounter {
+	ignoredCounts := make(map[string]*atomic.Int64, len(ignoredStatuses))
+	for _, status := range ignoredStatuses {
+		ignoredCounts[status] = &atomic.Int64{}
+	}
+
+	return &actionCounter{
+		counts:         map[ActionCountKey]*atomic.Int64{},
+		ignoredCounts:  ignoredCounts,
+		collectionName: collectionName,
+	}
+}
+
+func (c *actionCounter) inc(action *CBActionDocument, outcome string) {
+	key := ActionCountKey{ActionType: action.Type, CollectionName: action.CollectionName, Outcome: outcome}
+	if key.CollectionName == "" {
+		key.CollectionName = c.collectionName
+	}
+
+	c.mu.RLock()
+	counter, ok := c.counts[key]
+	c.mu.RUnlock()
+
+	if !ok {
+		c.mu.Lock()
+		if counter, ok = c.counts[key]; !ok {
+			counter = &atomic.Int64{}
+			c.counts[key] = counter
+		}
+		c.mu.Unlock()
+	}
+	counter.Add(1)
+}
+
+func (c *actionCounter) incIgnored(status string) {
+	c.ignoredCounts[status].Add(1)
+}
+
+func (c *actionCounter) snapshot() map[ActionCountKey]int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	counts := make(map[ActionCountKey]int64, len(c.counts))
+	for key, counter := range c.counts {
+		counts[key] = counter.Load()
+	}
+	return counts
+}
+
+func (c *actionCounter) ignoredSnapshot() map[string]int64 {
+	counts := make(map[string]int64, len(c.ignoredCounts))
+	for status, counter := range c.ignoredCounts {
+		counts[status] = counter.Load()
+	}
+	return counts
+}
@@ -0,0 +1,144 @@
+package couchbase
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+)
+
+type cacheEntry struct {
+	expiresAt time.Time
+	result    GetResult
+	key       string
+}
+
+type CacheMetric struct {
+	Hits   int64
+	Misses int64
+	Size   int64
+}
+
+// CachedTargetClient is a read-through LRU cache in front of TargetClient for enrichment lookups.
+// Only successful reads are cached, so missing documents are looked up again.
+// Values passed to the callback on a hit are shared and must not be modified.
+type CachedTargetClient struct {
+	TargetClient
+	entries map[string]*list.Element
+	pending map[string]uint64
+	lru     *list.List
+	ttl     time.Duration
+	size    int
+	hits    atomic.Int64
+	misses  atomic.Int64
+	nextGen uint64
+	mu      sync.Mutex
+}
+
+func NewCachedTargetClient(targetClient TargetClient, cfg *config.TargetClientCache) *CachedTargetClient {
+	return &CachedTargetClient{
+		TargetClient: targetClient,
+		entries:      make(map[string]*list.Element, cfg.Size),
+		pending:      make(map[string]uint64),
+		lru:          list.New(),
+		ttl:          cfg.TTL,
+		size:         cfg.Size,
+	}
+}
+
+func (c *CachedTargetClient) Get(ctx context.Context, id []byte, cb GetCallback) error {
+	key := string(id)
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(element)
+			result := entry.result
+			c.mu.Unlock()
+
+			c.hits.Add(1)
+			cb(&result, nil)
+			return nil
+		}
+		c.removeElement(element)
+	}
+	c.nextGen++
+	gen := c.nextGen
+	c.pending[key] = gen
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	err := c.TargetClient.Get(ctx, id, func(result *GetResult, err error) {
+		c.complete(key, gen, result, err)
+		cb(result, err)
+	})
+	if err != nil {
+		c.complete(key, gen, nil, err)
+	}
+	return err
+}
+
+// complete skips results whose key was invalidated or read again while the lookup was in flight.
+func (c *CachedTargetClient) complete(key string, gen uint64, result *GetResult, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending[key] != gen {
+		return
+	}
+	delete(c.pending, key)
+
+	if err != nil || result == nil {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:       key,
+		result:    GetResult{Value: append([]byte(nil), result.Value...), Cas: result.Cas},
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// Invalidate drops the cached document, it is called by the processor when the connector writes to id.
+func (c *CachedTargetClient) Invalidate(id []byte) {
+	key := string(id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, key)
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+func (c *CachedTargetClient) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+func (c *CachedTargetClient) GetMetric() CacheMetric {
+	c.mu.Lock()
+	size := int64(c.lru.Len())
+	c.mu.Unlock()
+
+	return CacheMetric{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   size,
+	}
+}
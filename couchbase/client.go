@@ -122,11 +122,17 @@ func (s *client) CreateMultiPath(ctx context.Context,
 	ops := make([]gocbcore.SubDocOp, len(pathValues))
 
 	for i, pv := range pathValues {
+		op, err := subDocOp(pv.Op)
+		if err != nil {
+			return err
+		}
+
 		ops[i] = gocbcore.SubDocOp{
-			Op:    memd.SubDocOpDictSet,
+			Op:    op,
 			Path:  string(pv.Path),
 			Value: pv.Value,
 		}
+		ops[i].Flags = subDocFlags(pv)
 	}
 
 	options := gocbcore.MutateInOptions{
@@ -150,6 +156,36 @@ func (s *client) CreateMultiPath(ctx context.Context,
 	return err
 }
 
+func subDocOp(op PathOp) (memd.SubDocOpType, error) {
+	switch op {
+	case PathUpsert:
+		return memd.SubDocOpDictSet, nil
+	case PathRemove:
+		return memd.SubDocOpDelete, nil
+	case PathReplace:
+		return memd.SubDocOpReplace, nil
+	case PathArrayInsert:
+		return memd.SubDocOpArrayInsert, nil
+	case PathArrayPushLast:
+		return memd.SubDocOpArrayPushLast, nil
+	case PathCounter:
+		return memd.SubDocOpCounter, nil
+	default:
+		return 0, fmt.Errorf("unexpected path op: %v", op)
+	}
+}
+
+func subDocFlags(pv PathValue) memd.SubdocFlag {
+	flags := memd.SubdocFlagNone
+	if pv.CreateParents {
+		flags |= memd.SubdocFlagMkDirP
+	}
+	if pv.Xattr {
+		flags |= memd.SubdocFlagXattrPath
+	}
+	return flags
+}
+
 func (s *client) CreatePath(ctx context.Context,
 	scopeName string,
 	collectionName string,
@@ -354,74 +390,86 @@ func (s *client) DeletePath(ctx context.Context,
 }
 
 func (s *client) Execute(ctx context.Context, action *CBActionDocument, callback func(error)) {
+	scopeName := s.config.ScopeName
+	if action.ScopeName != "" {
+		scopeName = action.ScopeName
+	}
+
+	collectionName := s.config.CollectionName
+	if action.CollectionName != "" {
+		collectionName = action.CollectionName
+	}
+
 	var err error
+	switch action.Type {
+	case Set, Delete, Increment:
+		err = s.executeDocument(ctx, scopeName, collectionName, action, callback)
+	case MutateIn, MultiMutateIn, ArrayAppend, DeletePath:
+		err = s.executeSubDocument(ctx, scopeName, collectionName, action, callback)
+	case MinMax:
+		err = s.MinMax(ctx, scopeName, collectionName, action, 1, callback)
+	default:
+		err = fmt.Errorf("unexpected action type: %v", action.Type)
+	}
+
+	if err != nil {
+		callback(err)
+	}
+}
+
+func (s *client) executeDocument(
+	ctx context.Context, scopeName string, collectionName string, action *CBActionDocument, callback func(error),
+) error {
 	casPtr := (*gocbcore.Cas)(action.Cas)
 
 	switch action.Type {
 	case Set:
-		err = s.CreateDocument(ctx, s.config.ScopeName, s.config.CollectionName,
+		return s.CreateDocument(ctx, scopeName, collectionName,
 			action.ID, action.Source, action.DocumentFlags, action.Expiry,
 			func(result *gocbcore.StoreResult, err error) {
 				callback(err)
 			})
-	case MutateIn:
-		subDocFlags := memd.SubdocDocFlagMkDoc
-		if action.DisableAutoCreate {
-			subDocFlags = memd.SubdocDocFlagNone
-		}
-
-		err = s.CreatePath(ctx, s.config.ScopeName, s.config.CollectionName,
-			action.ID, action.Path, action.Source, subDocFlags, casPtr, action.Expiry, action.PreserveExpiry,
-			func(result *gocbcore.MutateInResult, err error) {
-				callback(err)
-			})
-	case MultiMutateIn:
-		subDocFlags := memd.SubdocDocFlagMkDoc
-		if action.DisableAutoCreate {
-			subDocFlags = memd.SubdocDocFlagNone
-		}
-
-		err = s.CreateMultiPath(ctx, s.config.ScopeName, s.config.CollectionName,
-			action.ID, action.PathValues, subDocFlags, casPtr, action.Expiry, action.PreserveExpiry,
-			func(result *gocbcore.MutateInResult, err error) {
-				callback(err)
-			})
-	case ArrayAppend:
-		subDocFlags := memd.SubdocDocFlagMkDoc
-		if action.DisableAutoCreate {
-			subDocFlags = memd.SubdocDocFlagNone
-		}
-
-		err = s.ArrayAppend(ctx, s.config.ScopeName, s.config.CollectionName,
-			action.ID, action.Path, action.Source, subDocFlags, casPtr, action.Expiry, action.PreserveExpiry,
-			func(result *gocbcore.MutateInResult, err error) {
-				callback(err)
-			})
-	case DeletePath:
-		err = s.DeletePath(ctx, s.config.ScopeName, s.config.CollectionName,
-			action.ID, action.Path, casPtr, action.Expiry, action.PreserveExpiry,
-			func(result *gocbcore.MutateInResult, err error) {
-				callback(err)
-			})
 	case Delete:
-		err = s.DeleteDocument(ctx, s.config.ScopeName, s.config.CollectionName,
+		return s.DeleteDocument(ctx, scopeName, collectionName,
 			action.ID, casPtr,
 			func(result *gocbcore.DeleteResult, err error) {
 				callback(err)
 			})
-	case Increment:
-		err = s.Increment(ctx, s.config.ScopeName, s.config.CollectionName,
+	default:
+		return s.Increment(ctx, scopeName, collectionName,
 			action.ID, action.Delta, action.Initial, casPtr, action.Expiry, action.PreserveExpiry,
 			func(result *gocbcore.CounterResult, err error) {
 				callback(err)
 			})
-	default:
-		err = fmt.Errorf("unexpected action type: %v", action.Type)
 	}
+}
 
-	if err != nil {
+func (s *client) executeSubDocument(
+	ctx context.Context, scopeName string, collectionName string, action *CBActionDocument, callback func(error),
+) error {
+	casPtr := (*gocbcore.Cas)(action.Cas)
+	subDocFlags := memd.SubdocDocFlagMkDoc
+	if action.DisableAutoCreate {
+		subDocFlags = memd.SubdocDocFlagNone
+	}
+	mutateInCallback := func(result *gocbcore.MutateInResult, err error) {
 		callback(err)
 	}
+
+	switch action.Type {
+	case MutateIn:
+		return s.CreatePath(ctx, scopeName, collectionName,
+			action.ID, action.Path, action.Source, subDocFlags, casPtr, action.Expiry, action.PreserveExpiry, mutateInCallback)
+	case MultiMutateIn:
+		return s.CreateMultiPath(ctx, scopeName, collectionName,
+			action.ID, action.PathValues, subDocFlags, casPtr, action.Expiry, action.PreserveExpiry, mutateInCallback)
+	case ArrayAppend:
+		return s.ArrayAppend(ctx, scopeName, collectionName,
+			action.ID, action.Path, action.Source, subDocFlags, casPtr, action.Expiry, action.PreserveExpiry, mutateInCallback)
+	default:
+		return s.DeletePath(ctx, scopeName, collectionName,
+			action.ID, action.Path, casPtr, action.Expiry, action.PreserveExpiry, mutateInCallback)
+	}
 }
 
 func (s *client) Close() {
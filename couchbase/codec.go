@@ -0,0 +1,11 @@
+package couchbase
+
+import jsoniter "github.com/json-iterator/go"
+
+// Codec encodes values of typed mappers and typed actions.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var DefaultCodec Codec = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -0,0 +1,168 @@
+package couchbase
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Trendyol/go-dcp/logger"
+	"golang.org/x/time/rate"
+)
+
+var ErrProcessorPaused = errors.New("processor is paused")
+
+// Limits are the batch and write rate limits of the processor, a WriteRateLimit of 0 does not limit writes.
+type Limits struct {
+	BatchSizeLimit     int     `json:"batchSizeLimit"`
+	BatchByteSizeLimit int     `json:"batchByteSizeLimit"`
+	WriteRateLimit     float64 `json:"writeRateLimit"`
+}
+
+// LimitsUpdate changes the limits which are set, the others keep their current value.
+type LimitsUpdate struct {
+	BatchSizeLimit     *int     `json:"batchSizeLimit"`
+	BatchByteSizeLimit *int     `json:"batchByteSizeLimit"`
+	WriteRateLimit     *float64 `json:"writeRateLimit"`
+}
+
+// pauseGate blocks the listener while the processor is paused and its batch is full.
+type pauseGate struct {
+	resumeCh    chan struct{}
+	interruptCh chan struct{}
+	closeCh     chan struct{}
+	mu          sync.Mutex
+}
+
+func newPauseGate() *pauseGate {
+	return &pauseGate{interruptCh: make(chan struct{}), closeCh: make(chan struct{})}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	if g.resumeCh == nil {
+		g.resumeCh = make(chan struct{})
+	}
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	if g.resumeCh != nil {
+		close(g.resumeCh)
+		g.resumeCh = nil
+	}
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumeCh != nil
+}
+
+// wait returns once the gate is resumed, interrupted or closed.
+func (g *pauseGate) wait() {
+	g.mu.Lock()
+	resumeCh, interruptCh := g.resumeCh, g.interruptCh
+	g.mu.Unlock()
+
+	if resumeCh != nil {
+		select {
+		case <-resumeCh:
+		case <-interruptCh:
+		case <-g.closeCh:
+		}
+	}
+}
+
+// interrupt releases the current waiters without resuming the gate, so a rebalance is not blocked by them.
+func (g *pauseGate) interrupt() {
+	g.mu.Lock()
+	close(g.interruptCh)
+	g.interruptCh = make(chan struct{})
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) close() {
+	close(g.closeCh)
+}
+
+func newWriteLimiter(writeRateLimit float64) *rate.Limiter {
+	if writeRateLimit <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(writeRateLimit), writeRateBurst(writeRateLimit))
+}
+
+// waitWriteLimiter blocks until the write rate limit allows the next write. The request timeout of an action
+// starts after the wait, so a low limit delays the batch instead of failing its writes.
+func (b *Processor) waitWriteLimiter() {
+	if err := b.writeLimiter.Wait(context.Background()); err != nil {
+		logger.Log.Warn("error while wait write rate limit, err: %v", err)
+	}
+}
+
+func writeRateBurst(writeRateLimit float64) int {
+	return max(1, int(writeRateLimit))
+}
+
+// Pause stops writing and committing the checkpoint, the listener blocks once the batch is full.
+// Events stay in the batch or are streamed again after a restart, so no position is lost.
+func (b *Processor) Pause() {
+	b.pauseGate.pause()
+}
+
+func (b *Processor) Resume() {
+	b.pauseGate.resume()
+}
+
+func (b *Processor) IsPaused() bool {
+	return b.pauseGate.isPaused()
+}
+
+// Flush writes the batch and commits the checkpoint without waiting for the batch ticker.
+func (b *Processor) Flush() error {
+	if b.IsPaused() {
+		return ErrProcessorPaused
+	}
+	b.flushMessages()
+	return nil
+}
+
+func (b *Processor) GetLimits() Limits {
+	limit := b.writeLimiter.Limit()
+	if limit == rate.Inf {
+		limit = 0
+	}
+
+	return Limits{
+		BatchSizeLimit:     int(b.batchSizeLimit.Load()),
+		BatchByteSizeLimit: int(b.batchByteSizeLimit.Load()),
+		WriteRateLimit:     float64(limit),
+	}
+}
+
+// SetLimits applies the set limits, the batch limits apply to the next added actions.
+func (b *Processor) SetLimits(update LimitsUpdate) error {
+	if (update.BatchSizeLimit != nil && *update.BatchSizeLimit <= 0) ||
+		(update.BatchByteSizeLimit != nil && *update.BatchByteSizeLimit <= 0) ||
+		(update.WriteRateLimit != nil && *update.WriteRateLimit < 0) {
+		return errors.New("batch limits must be positive and the write rate limit must not be negative")
+	}
+
+	if update.BatchSizeLimit != nil {
+		b.batchSizeLimit.Store(int64(*update.BatchSizeLimit))
+	}
+	if update.BatchByteSizeLimit != nil {
+		b.batchByteSizeLimit.Store(int64(*update.BatchByteSizeLimit))
+	}
+	switch {
+	case update.WriteRateLimit == nil:
+	case *update.WriteRateLimit == 0:
+		b.writeLimiter.SetLimit(rate.Inf)
+	default:
+		b.writeLimiter.SetBurst(writeRateBurst(*update.WriteRateLimit))
+		b.writeLimiter.SetLimit(rate.Limit(*update.WriteRateLimit))
+	}
+	return nil
+}
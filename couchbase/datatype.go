@@ -0,0 +1,61 @@
+package couchbase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/golang/snappy"
+)
+
+const (
+	DatatypeJSON       uint8 = 0x01
+	DatatypeCompressed uint8 = 0x02
+	DatatypeXattrs     uint8 = 0x04
+)
+
+var errInvalidXattrs = errors.New("invalid xattrs section")
+
+// DecodeValue decompresses a snappy compressed DCP value and splits its xattrs section from the body.
+func DecodeValue(value []byte, datatype uint8) ([]byte, map[string][]byte, error) {
+	if datatype&DatatypeCompressed != 0 {
+		decoded, err := snappy.Decode(nil, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		value = decoded
+	}
+
+	if datatype&DatatypeXattrs == 0 {
+		return value, nil, nil
+	}
+
+	if len(value) < 4 {
+		return nil, nil, errInvalidXattrs
+	}
+	end := 4 + int(binary.BigEndian.Uint32(value))
+	if end > len(value) {
+		return nil, nil, errInvalidXattrs
+	}
+
+	xattrs := map[string][]byte{}
+	for section := value[4:end]; len(section) > 0; {
+		if len(section) < 4 {
+			return nil, nil, errInvalidXattrs
+		}
+		pairEnd := 4 + int(binary.BigEndian.Uint32(section))
+		if pairEnd > len(section) {
+			return nil, nil, errInvalidXattrs
+		}
+
+		// a pair is `key\x00value\x00`
+		pair := bytes.Split(section[4:pairEnd], []byte{0})
+		if len(pair) != 3 {
+			return nil, nil, errInvalidXattrs
+		}
+		xattrs[string(pair[0])] = pair[1]
+		section = section[pairEnd:]
+	}
+
+	return value[end:], xattrs, nil
+}
@@ -0,0 +1,131 @@
+package couchbase
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// maxSubDocOps is the server limit of operations in a single sub-document mutation.
+const maxSubDocOps = 16
+
+var diffCodec = jsoniter.Config{UseNumber: true}.Froze()
+
+// NewDiffActions compares the json objects previous and current stored at basePath of the target document
+// and returns a single MultiMutateIn action which sets the changed fields and removes the dropped ones,
+// so readers never see a half applied diff. Arrays and scalars are replaced as a whole. When previous is nil,
+// either side is not an object or the diff needs more than 16 operations, current is written as a whole
+// with Set, or with MutateIn if basePath is not empty.
+// With an empty basePath dropped fields are kept, they can not be told apart from fields of other writers.
+// No action is returned when nothing changed.
+//
+// The sets create missing parents. Path errors, also of a dropped field which is already missing,
+// fail the action instead of being ignored, since the subtree at basePath is owned by the mapper.
+func NewDiffActions(key []byte, basePath string, previous []byte, current []byte) ([]CBActionDocument, error) {
+	var currentDoc any
+	if err := diffCodec.Unmarshal(current, &currentDoc); err != nil {
+		return nil, err
+	}
+
+	var previousDoc any
+	if previous != nil {
+		if err := diffCodec.Unmarshal(previous, &previousDoc); err != nil {
+			return nil, err
+		}
+	}
+
+	previousObject, previousOk := previousDoc.(map[string]any)
+	currentObject, currentOk := currentDoc.(map[string]any)
+	if !previousOk || !currentOk {
+		if previous != nil && reflect.DeepEqual(previousDoc, currentDoc) {
+			return nil, nil
+		}
+		return []CBActionDocument{newWholeDiffAction(key, basePath, current)}, nil
+	}
+
+	d := &objectDiff{removes: basePath != ""}
+	if err := d.diff(basePath, previousObject, currentObject); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(d.ops) == 0:
+		return nil, nil
+	case len(d.ops) > maxSubDocOps:
+		return []CBActionDocument{newWholeDiffAction(key, basePath, current)}, nil
+	}
+	action := NewMultiMutateInAction(key, d.ops)
+	action.SetStrictPaths(true)
+	return []CBActionDocument{action}, nil
+}
+
+func newWholeDiffAction(key []byte, basePath string, current []byte) CBActionDocument {
+	if basePath == "" {
+		return NewSetAction(key, current)
+	}
+	return NewMutateInAction(key, []byte(basePath), current)
+}
+
+type objectDiff struct {
+	ops     []PathValue
+	removes bool
+}
+
+func (d *objectDiff) diff(prefix string, previous map[string]any, current map[string]any) error {
+	for _, field := range sortedFields(current) {
+		path := joinSubDocPath(prefix, field)
+		currentValue := current[field]
+
+		if previousValue, ok := previous[field]; ok {
+			previousObject, previousOk := previousValue.(map[string]any)
+			currentObject, currentOk := currentValue.(map[string]any)
+			if previousOk && currentOk {
+				if err := d.diff(path, previousObject, currentObject); err != nil {
+					return err
+				}
+				continue
+			}
+			if reflect.DeepEqual(previousValue, currentValue) {
+				continue
+			}
+		}
+
+		value, err := diffCodec.Marshal(currentValue)
+		if err != nil {
+			return err
+		}
+		d.ops = append(d.ops, PathValue{Op: PathUpsert, Path: []byte(path), Value: value, CreateParents: true})
+	}
+
+	if !d.removes {
+		return nil
+	}
+	for _, field := range sortedFields(previous) {
+		if _, ok := current[field]; !ok {
+			d.ops = append(d.ops, PathValue{Op: PathRemove, Path: []byte(joinSubDocPath(prefix, field))})
+		}
+	}
+	return nil
+}
+
+// joinSubDocPath quotes field with backticks when it contains characters of the sub-document path syntax.
+func joinSubDocPath(prefix string, field string) string {
+	if strings.ContainsAny(field, ".[]`") {
+		field = "`" + strings.ReplaceAll(field, "`", "``") + "`"
+	}
+	if prefix == "" {
+		return field
+	}
+	return prefix + "." + field
+}
+
+func sortedFields(object map[string]any) []string {
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
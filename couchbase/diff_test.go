@@ -0,0 +1,109 @@
+package couchbase
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func diffUpsert(path string, value string) PathValue {
+	return PathValue{Op: PathUpsert, Path: []byte(path), Value: []byte(value), CreateParents: true}
+}
+
+func diffRemove(path string) PathValue {
+	return PathValue{Op: PathRemove, Path: []byte(path)}
+}
+
+func strictMultiMutateIn(ops ...PathValue) CBActionDocument {
+	action := NewMultiMutateInAction([]byte("k"), ops)
+	action.SetStrictPaths(true)
+	return action
+}
+
+func manyFields(count int, value int) string {
+	fields := make([]string, count)
+	for i := range fields {
+		fields[i] = fmt.Sprintf(`"f%02d":%v`, i, value)
+	}
+	return "{" + strings.Join(fields, ",") + "}"
+}
+
+var diffActionsTests = []struct {
+	name     string
+	basePath string
+	previous string
+	current  string
+	want     []CBActionDocument
+}{
+	{
+		name:    "missing previous writes the whole document",
+		current: `{"a":1}`,
+		want:    []CBActionDocument{NewSetAction([]byte("k"), []byte(`{"a":1}`))},
+	},
+	{
+		name:     "missing previous writes the whole subtree",
+		basePath: "source",
+		current:  `{"a":1}`,
+		want:     []CBActionDocument{NewMutateInAction([]byte("k"), []byte("source"), []byte(`{"a":1}`))},
+	},
+	{
+		name:     "nothing changed",
+		previous: `{"a":1,"b":{"c":[1,2]}}`,
+		current:  `{"b":{"c":[1,2]},"a":1}`,
+	},
+	{
+		name:     "whole document diff keeps dropped fields",
+		previous: `{"a":1,"b":{"c":1,"d":2},"e":3}`,
+		current:  `{"a":2,"b":{"c":1,"d":3}}`,
+		want:     []CBActionDocument{strictMultiMutateIn(diffUpsert("a", `2`), diffUpsert("b.d", `3`))},
+	},
+	{
+		name:     "sets and removes are written in one mutation",
+		basePath: "source",
+		previous: `{"a":1,"b":{"c":1,"d":2},"e":3}`,
+		current:  `{"a":2,"b":{"c":1},"f.g":4}`,
+		want: []CBActionDocument{strictMultiMutateIn(
+			diffUpsert("source.a", `2`), diffRemove("source.b.d"),
+			diffUpsert("source.`f.g`", `4`), diffRemove("source.e"),
+		)},
+	},
+	{
+		name:     "16 operations are written in one mutation",
+		previous: manyFields(16, 1),
+		current:  manyFields(16, 2),
+		want: []CBActionDocument{strictMultiMutateIn(func() []PathValue {
+			ops := make([]PathValue, 16)
+			for i := range ops {
+				ops[i] = diffUpsert(fmt.Sprintf("f%02d", i), `2`)
+			}
+			return ops
+		}()...)},
+	},
+	{
+		name:     "more than 16 operations write the whole subtree",
+		basePath: "source",
+		previous: manyFields(17, 1),
+		current:  manyFields(17, 2),
+		want:     []CBActionDocument{NewMutateInAction([]byte("k"), []byte("source"), []byte(manyFields(17, 2)))},
+	},
+}
+
+func TestNewDiffActions(t *testing.T) {
+	for _, tt := range diffActionsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var previous []byte
+			if tt.previous != "" {
+				previous = []byte(tt.previous)
+			}
+
+			actions, err := NewDiffActions([]byte("k"), tt.basePath, previous, []byte(tt.current))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actions, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, actions)
+			}
+		})
+	}
+}
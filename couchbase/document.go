@@ -1,10 +1,33 @@
 package couchbase
 
+import "go.opentelemetry.io/otel/trace"
+
 type CbAction string
 
+// PathOp is the sub-document operation of a PathValue, the zero value sets the path.
+type PathOp string
+
+const (
+	PathUpsert        PathOp = ""
+	PathRemove        PathOp = "Remove"
+	PathReplace       PathOp = "Replace"
+	PathArrayInsert   PathOp = "ArrayInsert"
+	PathArrayPushLast PathOp = "ArrayPushLast"
+	// PathCounter adds the json integer Value to the number at Path.
+	PathCounter PathOp = "Counter"
+	// PathMin and PathMax keep the lower or higher json number at Path, they are only valid in a MinMax action.
+	PathMin PathOp = "Min"
+	PathMax PathOp = "Max"
+)
+
 type PathValue struct {
+	Op    PathOp
 	Path  []byte
 	Value []byte
+	// CreateParents creates missing objects on the way to Path instead of failing.
+	CreateParents bool
+	// Xattr addresses an extended attribute instead of the document body.
+	Xattr bool
 }
 
 const (
@@ -15,6 +38,7 @@ const (
 	DeletePath    CbAction = "DeletePath"
 	ArrayAppend   CbAction = "ArrayAppend"
 	Increment     CbAction = "Increment"
+	MinMax        CbAction = "MinMax"
 )
 
 type CBActionDocument struct {
@@ -24,8 +48,12 @@ type CBActionDocument struct {
 	// It is used to control data format (JSON, binary, string) and compression.
 	// The value should be generated using gocbcore.EncodeCommonFlags.
 	// If left as 0 (default), the SDK will attempt to infer the data type.
-	DocumentFlags     uint32
-	PathValues        []PathValue
+	DocumentFlags uint32
+	PathValues    []PathValue
+	// ScopeName and CollectionName route the action to another target collection,
+	// the configured `couchbase.scopeName` and `couchbase.collectionName` are used when empty.
+	ScopeName         string
+	CollectionName    string
 	Source            []byte
 	ID                []byte
 	Path              []byte
@@ -33,8 +61,18 @@ type CBActionDocument struct {
 	Expiry            uint32
 	PreserveExpiry    bool
 	DisableAutoCreate bool
-	Initial           uint64
-	Delta             uint64
+	// StrictPaths reports path_not_found and bad_multi statuses as write errors,
+	// by default they are ignored like a missing document.
+	StrictPaths bool
+	// BestEffort actions only report write errors to their written callbacks,
+	// the SinkResponseHandler is not called and the connector does not stop.
+	BestEffort bool
+	Initial    uint64
+	Delta      uint64
+	origin     eventOrigin
+	// eventSpanContext is not spooled, replayed actions are not linked to their event.
+	eventSpanContext trace.SpanContext
+	onWritten        func(err error)
 }
 
 func (doc *CBActionDocument) SetCas(cas uint64) {
@@ -60,6 +98,48 @@ func (doc *CBActionDocument) SetDisableAutoCreate(value bool) {
 	doc.DisableAutoCreate = value
 }
 
+func (doc *CBActionDocument) SetStrictPaths(value bool) {
+	doc.StrictPaths = value
+}
+
+func (doc *CBActionDocument) SetBestEffort(value bool) {
+	doc.BestEffort = value
+}
+
+// SetEventSpanContext links the spans of the action to the span of the event it was mapped from.
+func (doc *CBActionDocument) SetEventSpanContext(spanContext trace.SpanContext) {
+	doc.eventSpanContext = spanContext
+}
+
+// AddWrittenCallback adds fn to be called once the action is written, after the callbacks added before.
+// err is nil when the write succeeded or its error status is ignored. A spooled action counts as written,
+// the spool replays it in order. fn is not called for actions dropped from the batch when a rebalance starts.
+func (doc *CBActionDocument) AddWrittenCallback(fn func(err error)) {
+	previous := doc.onWritten
+	if previous == nil {
+		doc.onWritten = fn
+		return
+	}
+	doc.onWritten = func(err error) {
+		previous(err)
+		fn(err)
+	}
+}
+
+func (doc *CBActionDocument) written(err error) {
+	if doc.onWritten != nil {
+		doc.onWritten(err)
+	}
+}
+
+func (doc *CBActionDocument) SetScopeName(scopeName string) {
+	doc.ScopeName = scopeName
+}
+
+func (doc *CBActionDocument) SetCollectionName(collectionName string) {
+	doc.CollectionName = collectionName
+}
+
 func NewDeleteAction(key []byte) CBActionDocument {
 	return CBActionDocument{
 		ID:   key,
@@ -91,6 +171,14 @@ func NewMultiMutateInAction(key []byte, pathValues []PathValue) CBActionDocument
 	}
 }
 
+// NewMinMaxAction keeps the lowest(PathMin) or highest(PathMax) value of every path.
+// It is applied with a read and compare-and-swap loop, so it costs at least two round trips.
+func NewMinMaxAction(key []byte, pathValues []PathValue) CBActionDocument {
+	action := NewMultiMutateInAction(key, pathValues)
+	action.Type = MinMax
+	return action
+}
+
 func NewMutateInAction(key []byte, path []byte, source []byte) CBActionDocument {
 	return CBActionDocument{
 		ID:     key,
@@ -129,3 +217,39 @@ func NewArrayAppendAction(key []byte, path []byte, source []byte) CBActionDocume
 		Size:   len(key) + len(path) + len(source),
 	}
 }
+
+// NewTypedSetAction marshals value with DefaultCodec, so strings are always quoted and numbers are valid json.
+func NewTypedSetAction(key []byte, value any) (CBActionDocument, error) {
+	source, err := DefaultCodec.Marshal(value)
+	if err != nil {
+		return CBActionDocument{}, err
+	}
+	return NewSetAction(key, source), nil
+}
+
+// NewTypedMutateInAction marshals value with DefaultCodec before setting it to path.
+func NewTypedMutateInAction(key []byte, path []byte, value any) (CBActionDocument, error) {
+	source, err := DefaultCodec.Marshal(value)
+	if err != nil {
+		return CBActionDocument{}, err
+	}
+	return NewMutateInAction(key, path, source), nil
+}
+
+// NewTypedArrayAppendAction marshals value with DefaultCodec before appending it to the array at path.
+func NewTypedArrayAppendAction(key []byte, path []byte, value any) (CBActionDocument, error) {
+	source, err := DefaultCodec.Marshal(value)
+	if err != nil {
+		return CBActionDocument{}, err
+	}
+	return NewArrayAppendAction(key, path, source), nil
+}
+
+// NewTypedPathValue marshals value with DefaultCodec for NewMultiMutateInAction.
+func NewTypedPathValue(path []byte, value any) (PathValue, error) {
+	encoded, err := DefaultCodec.Marshal(value)
+	if err != nil {
+		return PathValue{}, err
+	}
+	return PathValue{Path: path, Value: encoded}, nil
+}
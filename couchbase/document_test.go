@@ -0,0 +1,122 @@
+package couchbase
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type prefixCodec struct{}
+
+func (prefixCodec) Marshal(v any) ([]byte, error) {
+	if s, ok := v.(string); ok && s == "fail" {
+		return nil, errors.New("codec failed")
+	}
+	return append([]byte("codec:"), v.(string)...), nil
+}
+
+func (prefixCodec) Unmarshal([]byte, any) error {
+	return nil
+}
+
+func TestTypedActions(t *testing.T) {
+	key, path := []byte("k"), []byte("a.b")
+
+	tests := []struct {
+		build func(value any) (CBActionDocument, error)
+		value any
+		want  CBActionDocument
+		name  string
+	}{
+		{
+			name:  "string set is quoted",
+			build: func(value any) (CBActionDocument, error) { return NewTypedSetAction(key, value) },
+			value: "mug",
+			want:  NewSetAction(key, []byte(`"mug"`)),
+		},
+		{
+			name:  "struct set",
+			build: func(value any) (CBActionDocument, error) { return NewTypedSetAction(key, value) },
+			value: struct {
+				Price int `json:"price"`
+			}{Price: 10},
+			want: NewSetAction(key, []byte(`{"price":10}`)),
+		},
+		{
+			name:  "mutate in",
+			build: func(value any) (CBActionDocument, error) { return NewTypedMutateInAction(key, path, value) },
+			value: 1.5,
+			want:  NewMutateInAction(key, path, []byte(`1.5`)),
+		},
+		{
+			name:  "array append",
+			build: func(value any) (CBActionDocument, error) { return NewTypedArrayAppendAction(key, path, value) },
+			value: []string{"x"},
+			want:  NewArrayAppendAction(key, path, []byte(`["x"]`)),
+		},
+		{
+			name: "path value",
+			build: func(value any) (CBActionDocument, error) {
+				pathValue, err := NewTypedPathValue(path, value)
+				return NewMultiMutateInAction(key, []PathValue{pathValue}), err
+			},
+			value: nil,
+			want:  NewMultiMutateInAction(key, []PathValue{{Path: path, Value: []byte(`null`)}}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTypedActions_MarshalError(t *testing.T) {
+	builders := map[string]func() error{
+		"set": func() error {
+			_, err := NewTypedSetAction([]byte("k"), make(chan int))
+			return err
+		},
+		"mutate in": func() error {
+			_, err := NewTypedMutateInAction([]byte("k"), []byte("a"), make(chan int))
+			return err
+		},
+		"array append": func() error {
+			_, err := NewTypedArrayAppendAction([]byte("k"), []byte("a"), make(chan int))
+			return err
+		},
+		"path value": func() error {
+			_, err := NewTypedPathValue([]byte("a"), make(chan int))
+			return err
+		},
+	}
+
+	for name, build := range builders {
+		t.Run(name, func(t *testing.T) {
+			if build() == nil {
+				t.Fatal("expected a marshal error")
+			}
+		})
+	}
+}
+
+func TestTypedActions_DefaultCodec(t *testing.T) {
+	defaultCodec := DefaultCodec
+	DefaultCodec = prefixCodec{}
+	defer func() { DefaultCodec = defaultCodec }()
+
+	action, err := NewTypedSetAction([]byte("k"), "mug")
+	if err != nil || string(action.Source) != "codec:mug" {
+		t.Fatalf("expected the value encoded by the default codec, got %s %v", action.Source, err)
+	}
+	if _, err = NewTypedSetAction([]byte("k"), "fail"); err == nil {
+		t.Fatal("expected the codec error")
+	}
+}
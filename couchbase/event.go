@@ -2,18 +2,43 @@ package couchbase
 
 import "time"
 
+const (
+	MutationEventType   = "mutation"
+	DeletionEventType   = "deletion"
+	ExpirationEventType = "expiration"
+)
+
 type Event struct {
+	// Xattrs are the extended attributes of the source document, nil when the stream did not include them.
+	Xattrs         map[string][]byte
+	ScopeName      string
 	CollectionName string
 	EventTime      time.Time
 	Key            []byte
-	Value          []byte
-	Cas            uint64
-	VbID           uint16
-	IsDeleted      bool
-	IsExpired      bool
-	IsMutated      bool
-	SeqNo          uint64
-	RevNo          uint64
+	// Value is the document body, decompressed and without the xattrs section.
+	Value     []byte
+	Cas       uint64
+	VbID      uint16
+	IsDeleted bool
+	IsExpired bool
+	IsMutated bool
+	SeqNo     uint64
+	RevNo     uint64
+	// Flags and Expiry are the common flags and absolute expiry of a mutation, Expiry is 0 when it never expires.
+	Flags  uint32
+	Expiry uint32
+	// Datatype is the datatype of the value as received from DCP, see DatatypeJSON, DatatypeCompressed and DatatypeXattrs.
+	Datatype uint8
+}
+
+// EventOptions are the Event fields which are not arguments of NewMutateEvent, NewDeleteEvent and NewExpireEvent.
+type EventOptions struct {
+	Xattrs    map[string][]byte
+	ScopeName string
+	// Flags and Expiry only apply to mutations.
+	Flags    uint32
+	Expiry   uint32
+	Datatype uint8
 }
 
 func NewDeleteEvent(
@@ -66,3 +91,38 @@ func NewMutateEvent(
 		SeqNo:          seqNo,
 	}
 }
+
+// WithOptions returns a copy of e with the fields of options set.
+func (e Event) WithOptions(options EventOptions) Event {
+	e.Xattrs = options.Xattrs
+	e.ScopeName = options.ScopeName
+	e.Flags = options.Flags
+	e.Expiry = options.Expiry
+	e.Datatype = options.Datatype
+	return e
+}
+
+func (e *Event) Type() string {
+	switch {
+	case e.IsDeleted:
+		return DeletionEventType
+	case e.IsExpired:
+		return ExpirationEventType
+	default:
+		return MutationEventType
+	}
+}
+
+// IsJSON reports whether the server detected the value as json, binary documents are not json.
+func (e *Event) IsJSON() bool {
+	return e.Datatype&DatatypeJSON != 0
+}
+
+// IsCompressed reports whether the value was snappy compressed on the stream, Value is always decompressed.
+func (e *Event) IsCompressed() bool {
+	return e.Datatype&DatatypeCompressed != 0
+}
+
+func (e *Event) HasXattrs() bool {
+	return e.Datatype&DatatypeXattrs != 0
+}
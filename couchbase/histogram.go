@@ -0,0 +1,52 @@
+package couchbase
+
+import (
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp/helpers"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewLatencyHistogramOpts returns the options of a connector latency histogram in seconds,
+// name is prefixed like the other connector metrics.
+func NewLatencyHistogramOpts(name string, help string, cfg config.Histogram) prometheus.HistogramOpts {
+	return prometheus.HistogramOpts{
+		Name:                            prometheus.BuildFQName(helpers.Name, name, "seconds"),
+		Help:                            help,
+		Buckets:                         cfg.Buckets,
+		NativeHistogramBucketFactor:     cfg.NativeBucketFactor,
+		NativeHistogramMaxBucketNumber:  cfg.NativeMaxBucketNumber,
+		NativeHistogramMinResetDuration: time.Hour,
+	}
+}
+
+type processorHistograms struct {
+	eventLatency  prometheus.Histogram
+	actionLatency *prometheus.HistogramVec
+	flushLatency  prometheus.Histogram
+}
+
+func newProcessorHistograms(cfg config.Histogram) *processorHistograms {
+	return &processorHistograms{
+		eventLatency: prometheus.NewHistogram(NewLatencyHistogramOpts(
+			"couchbase_connector_event_latency",
+			"Couchbase connector latency from mutation creation in Couchbase to the write of its actions",
+			cfg,
+		)),
+		actionLatency: prometheus.NewHistogramVec(NewLatencyHistogramOpts(
+			"couchbase_connector_action_latency",
+			"Couchbase connector round trip latency of an action to the target bucket",
+			cfg,
+		), []string{"action_type"}),
+		flushLatency: prometheus.NewHistogram(NewLatencyHistogramOpts(
+			"couchbase_connector_flush_latency",
+			"Couchbase connector latency of writing a batch to the target bucket",
+			cfg,
+		)),
+	}
+}
+
+func (h *processorHistograms) collectors() []prometheus.Collector {
+	return []prometheus.Collector{h.eventLatency, h.actionLatency, h.flushLatency}
+}
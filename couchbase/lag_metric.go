@@ -0,0 +1,41 @@
+package couchbase
+
+import (
+	"sync"
+	"time"
+)
+
+// LagMetric tells how far the target bucket is behind the source.
+type LagMetric struct {
+	LastWrittenSeqNos map[uint16]uint64
+	BufferedActions   int64
+	BufferedByteSize  int64
+	// OldestUnflushedAge is the age of the oldest event whose actions are not written yet, 0 when nothing is buffered.
+	OldestUnflushedAge time.Duration
+	// SinceLastFlush is the time since the buffer was last emptied by a successful write.
+	SinceLastFlush time.Duration
+}
+
+type vbucketSeqNos struct {
+	seqNos map[uint16]uint64
+	mu     sync.RWMutex
+}
+
+func (v *vbucketSeqNos) update(vbID uint16, seqNo uint64) {
+	v.mu.Lock()
+	if seqNo > v.seqNos[vbID] {
+		v.seqNos[vbID] = seqNo
+	}
+	v.mu.Unlock()
+}
+
+func (v *vbucketSeqNos) snapshot() map[uint16]uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	seqNos := make(map[uint16]uint64, len(v.seqNos))
+	for vbID, seqNo := range v.seqNos {
+		seqNos[vbID] = seqNo
+	}
+	return seqNos
+}
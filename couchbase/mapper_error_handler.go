@@ -0,0 +1,11 @@
+package couchbase
+
+type MapperErrorHandlerContext struct {
+	TargetClient
+	Err   error
+	Event Event
+}
+
+type MapperErrorHandler interface {
+	OnError(ctx *MapperErrorHandlerContext)
+}
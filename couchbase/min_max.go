@@ -0,0 +1,141 @@
+package couchbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
+)
+
+const maxMinMaxAttempts = 16
+
+// MinMax reads the paths of a MinMax action and writes the winning values with the read CAS.
+// It starts over when another writer changed or created the document in between.
+func (s *client) MinMax(ctx context.Context,
+	scopeName string,
+	collectionName string,
+	action *CBActionDocument,
+	attempt int,
+	callback func(error),
+) error {
+	deadline, _ := ctx.Deadline()
+
+	lookups := make([]gocbcore.SubDocOp, len(action.PathValues))
+	for i, pv := range action.PathValues {
+		lookups[i] = gocbcore.SubDocOp{
+			Op:    memd.SubDocOpGet,
+			Flags: subDocFlags(PathValue{Xattr: pv.Xattr}),
+			Path:  string(pv.Path),
+		}
+	}
+
+	_, err := s.agent.LookupIn(gocbcore.LookupInOptions{
+		Key:            action.ID,
+		Ops:            lookups,
+		Deadline:       deadline,
+		ScopeName:      scopeName,
+		CollectionName: collectionName,
+		RetryStrategy:  gocbcore.NewBestEffortRetryStrategy(nil),
+	}, func(result *gocbcore.LookupInResult, err error) {
+		options, err := minMaxMutation(action, result, err)
+		if err != nil || len(options.Ops) == 0 {
+			callback(err)
+			return
+		}
+
+		options.Deadline = deadline
+		options.ScopeName = scopeName
+		options.CollectionName = collectionName
+
+		_, err = s.agent.MutateIn(options, func(_ *gocbcore.MutateInResult, err error) {
+			if attempt < maxMinMaxAttempts &&
+				(errors.Is(err, gocbcore.ErrCasMismatch) || errors.Is(err, gocbcore.ErrDocumentExists)) {
+				err = s.MinMax(ctx, scopeName, collectionName, action, attempt+1, callback)
+				if err == nil {
+					return
+				}
+			}
+			callback(err)
+		})
+		if err != nil {
+			callback(err)
+		}
+	})
+
+	return err
+}
+
+// minMaxMutation inserts the document when it is missing, otherwise it only sets the winning paths with the read CAS.
+func minMaxMutation(action *CBActionDocument, result *gocbcore.LookupInResult, err error) (gocbcore.MutateInOptions, error) {
+	options := gocbcore.MutateInOptions{
+		Key:            action.ID,
+		Expiry:         action.Expiry,
+		PreserveExpiry: action.PreserveExpiry,
+		RetryStrategy:  gocbcore.NewBestEffortRetryStrategy(nil),
+	}
+
+	if errors.Is(err, gocbcore.ErrDocumentNotFound) {
+		options.Flags = memd.SubdocDocFlagAddDoc
+		for _, pv := range action.PathValues {
+			options.Ops = append(options.Ops, minMaxSetOp(pv))
+		}
+		return options, nil
+	}
+	if err != nil {
+		return options, err
+	}
+
+	options.Cas = result.Cas
+	for i, pv := range action.PathValues {
+		wins, err := minMaxWins(pv, result.Ops[i])
+		if err != nil {
+			return options, err
+		}
+		if wins {
+			options.Ops = append(options.Ops, minMaxSetOp(pv))
+		}
+	}
+	return options, nil
+}
+
+func minMaxSetOp(pv PathValue) gocbcore.SubDocOp {
+	return gocbcore.SubDocOp{
+		Op:    memd.SubDocOpDictSet,
+		Flags: subDocFlags(PathValue{Xattr: pv.Xattr, CreateParents: true}),
+		Path:  string(pv.Path),
+		Value: pv.Value,
+	}
+}
+
+// minMaxWins reports whether the value of pv replaces the current one,
+// a missing or non-numeric current value is always replaced.
+func minMaxWins(pv PathValue, current gocbcore.SubDocResult) (bool, error) {
+	if errors.Is(current.Err, gocbcore.ErrPathNotFound) {
+		return true, nil
+	}
+	if current.Err != nil {
+		return false, current.Err
+	}
+
+	candidate, err := strconv.ParseFloat(string(pv.Value), 64)
+	if err != nil {
+		return false, fmt.Errorf("%v value of %s is not a number: %w", pv.Op, pv.Path, err)
+	}
+
+	existing, parseErr := strconv.ParseFloat(string(current.Value), 64)
+	if parseErr != nil {
+		return true, nil
+	}
+
+	switch pv.Op {
+	case PathMin:
+		return candidate < existing, nil
+	case PathMax:
+		return candidate > existing, nil
+	default:
+		return false, fmt.Errorf("unexpected path op for min max: %v", pv.Op)
+	}
+}
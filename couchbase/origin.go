@@ -0,0 +1,37 @@
+package couchbase
+
+import (
+	"time"
+
+	"github.com/Trendyol/go-dcp/models"
+)
+
+// eventOrigin is the source event of an action, it is zero for actions replayed from the spool.
+type eventOrigin struct {
+	time           time.Time
+	eventType      string
+	collectionName string
+	key            []byte
+	seqNo          uint64
+	vbID           uint16
+}
+
+func newEventOrigin(ctx *models.ListenerContext, eventTime time.Time) eventOrigin {
+	origin := eventOrigin{time: eventTime}
+	if ctx == nil {
+		return origin
+	}
+
+	switch event := ctx.Event.(type) {
+	case models.DcpMutation:
+		origin.eventType, origin.collectionName = MutationEventType, event.CollectionName
+		origin.key, origin.vbID, origin.seqNo = event.Key, event.VbID, event.SeqNo
+	case models.DcpDeletion:
+		origin.eventType, origin.collectionName = DeletionEventType, event.CollectionName
+		origin.key, origin.vbID, origin.seqNo = event.Key, event.VbID, event.SeqNo
+	case models.DcpExpiration:
+		origin.eventType, origin.collectionName = ExpirationEventType, event.CollectionName
+		origin.key, origin.vbID, origin.seqNo = event.Key, event.VbID, event.SeqNo
+	}
+	return origin
+}
@@ -0,0 +1,272 @@
+package couchbase
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+type jsonPatchOperation struct {
+	Path  *string             `json:"path"`
+	From  *string             `json:"from"`
+	Op    string              `json:"op"`
+	Value jsoniter.RawMessage `json:"value"`
+}
+
+// NewMergePatchAction compiles an RFC 7386 merge patch into a MultiMutateIn action.
+// Null members remove the path, objects are merged recursively and other values are set, creating missing parents.
+// A patch which is not an object replaces the whole document. A patch without operations, like `{}`, is a no-op
+// and no action is returned. Unlike RFC 7386, removing a path which does not exist and merging an object into
+// a scalar fail the mutation, and empty objects in the patch are ignored.
+// The action reports these path errors instead of ignoring them, see CBActionDocument.StrictPaths.
+func NewMergePatchAction(key []byte, patch []byte) ([]CBActionDocument, error) {
+	var doc any
+	if err := diffCodec.Unmarshal(patch, &doc); err != nil {
+		return nil, fmt.Errorf("merge patch: %w", err)
+	}
+
+	object, ok := doc.(map[string]any)
+	if !ok {
+		return []CBActionDocument{NewSetAction(key, patch)}, nil
+	}
+
+	var pathValues []PathValue
+	if err := mergePatchPaths("", object, &pathValues); err != nil {
+		return nil, fmt.Errorf("merge patch: %w", err)
+	}
+	if len(pathValues) == 0 {
+		return nil, nil
+	}
+	if err := validatePathValueCount(pathValues); err != nil {
+		return nil, fmt.Errorf("merge patch: %w", err)
+	}
+
+	action := NewMultiMutateInAction(key, pathValues)
+	action.SetStrictPaths(true)
+	return []CBActionDocument{action}, nil
+}
+
+func mergePatchPaths(prefix string, patch map[string]any, pathValues *[]PathValue) error {
+	for _, field := range sortedFields(patch) {
+		path := joinSubDocPath(prefix, field)
+
+		switch value := patch[field].(type) {
+		case nil:
+			*pathValues = append(*pathValues, PathValue{Op: PathRemove, Path: []byte(path)})
+		case map[string]any:
+			if err := mergePatchPaths(path, value, pathValues); err != nil {
+				return err
+			}
+		default:
+			encoded, err := diffCodec.Marshal(value)
+			if err != nil {
+				return err
+			}
+			*pathValues = append(*pathValues, PathValue{Path: []byte(path), Value: encoded, CreateParents: true})
+		}
+	}
+	return nil
+}
+
+// NewJSONPatchAction compiles an RFC 6902 patch into a MultiMutateIn action, applied atomically.
+// add, remove and replace are supported, move and copy are rejected.
+//
+// base is the current target document read by the mapper and may be nil. test operations are evaluated against
+// base and must precede the other operations, a failing test returns an error. When base is set, the action
+// carries its CAS, so the mutation fails instead of being applied to a document changed after the tests.
+// base also tells whether a numeric path segment is an array index, without it numeric segments are indices.
+// Like RFC 6902, an operation on a missing path fails the whole patch, the action reports it as a write error.
+func NewJSONPatchAction(key []byte, patch []byte, base *GetResult) (CBActionDocument, error) {
+	var operations []jsonPatchOperation
+	if err := DefaultCodec.Unmarshal(patch, &operations); err != nil {
+		return CBActionDocument{}, fmt.Errorf("json patch: %w", err)
+	}
+
+	var baseDoc any
+	if base != nil {
+		if err := DefaultCodec.Unmarshal(base.Value, &baseDoc); err != nil {
+			return CBActionDocument{}, fmt.Errorf("json patch base: %w", err)
+		}
+	}
+
+	var pathValues []PathValue
+	for i, operation := range operations {
+		pathValue, isTest, err := compileJSONPatchOperation(operation, base != nil, baseDoc)
+		if err == nil && isTest && len(pathValues) > 0 {
+			err = errors.New("test operations must precede the other operations")
+		}
+		if err != nil {
+			return CBActionDocument{}, fmt.Errorf("json patch operation %v: %w", i, err)
+		}
+		if !isTest {
+			pathValues = append(pathValues, pathValue)
+		}
+	}
+
+	if err := validatePathValueCount(pathValues); err != nil {
+		return CBActionDocument{}, fmt.Errorf("json patch: %w", err)
+	}
+
+	action := NewMultiMutateInAction(key, pathValues)
+	action.SetStrictPaths(true)
+	if base != nil {
+		action.SetCas(base.Cas)
+	}
+	return action, nil
+}
+
+func compileJSONPatchOperation(operation jsonPatchOperation, hasBase bool, baseDoc any) (PathValue, bool, error) {
+	if operation.Path == nil {
+		return PathValue{}, false, errors.New("missing path")
+	}
+
+	tokens, err := parseJSONPointer(*operation.Path)
+	if err != nil {
+		return PathValue{}, false, err
+	}
+
+	if operation.Op == "test" {
+		if !hasBase {
+			return PathValue{}, true, errors.New("test operations need the base document")
+		}
+		return PathValue{}, true, testJSONPatchOperation(operation, tokens, baseDoc)
+	}
+
+	if len(tokens) == 0 {
+		return PathValue{}, false, fmt.Errorf("%q on the whole document is not supported", operation.Op)
+	}
+
+	switch operation.Op {
+	case "add":
+		if operation.Value == nil {
+			return PathValue{}, false, errors.New("missing value")
+		}
+		parent, last := tokens[:len(tokens)-1], tokens[len(tokens)-1]
+		if last == "-" {
+			path := subDocPathFromPointer(parent, hasBase, baseDoc)
+			return PathValue{Op: PathArrayPushLast, Path: []byte(path), Value: operation.Value}, false, nil
+		}
+		path := subDocPathFromPointer(tokens, hasBase, baseDoc)
+		if strings.HasSuffix(path, "]") {
+			return PathValue{Op: PathArrayInsert, Path: []byte(path), Value: operation.Value}, false, nil
+		}
+		return PathValue{Path: []byte(path), Value: operation.Value}, false, nil
+	case "remove":
+		path := subDocPathFromPointer(tokens, hasBase, baseDoc)
+		return PathValue{Op: PathRemove, Path: []byte(path)}, false, nil
+	case "replace":
+		if operation.Value == nil {
+			return PathValue{}, false, errors.New("missing value")
+		}
+		path := subDocPathFromPointer(tokens, hasBase, baseDoc)
+		return PathValue{Op: PathReplace, Path: []byte(path), Value: operation.Value}, false, nil
+	case "move", "copy":
+		return PathValue{}, false, fmt.Errorf("%q is not supported", operation.Op)
+	default:
+		return PathValue{}, false, fmt.Errorf("unknown op %q", operation.Op)
+	}
+}
+
+func testJSONPatchOperation(operation jsonPatchOperation, tokens []string, baseDoc any) error {
+	if operation.Value == nil {
+		return errors.New("missing value")
+	}
+
+	var expected any
+	if err := DefaultCodec.Unmarshal(operation.Value, &expected); err != nil {
+		return err
+	}
+
+	actual, ok := resolveJSONPointer(baseDoc, tokens)
+	if !ok || !reflect.DeepEqual(actual, expected) {
+		return fmt.Errorf("test failed at %q", *operation.Path)
+	}
+	return nil
+}
+
+// parseJSONPointer splits an RFC 6901 pointer into unescaped reference tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid json pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func resolveJSONPointer(doc any, tokens []string) (any, bool) {
+	current := doc
+	for _, token := range tokens {
+		switch container := current.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []any:
+			index, ok := arrayIndex(token)
+			if !ok || index >= len(container) {
+				return nil, false
+			}
+			current = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// subDocPathFromPointer converts reference tokens to a sub-document path, a numeric token is an array index
+// when the base document has an array there, or always when there is no base document.
+func subDocPathFromPointer(tokens []string, hasBase bool, baseDoc any) string {
+	path := ""
+	for i, token := range tokens {
+		if index, ok := arrayIndex(token); ok {
+			isArray := !hasBase
+			if hasBase {
+				container, _ := resolveJSONPointer(baseDoc, tokens[:i])
+				_, isArray = container.([]any)
+			}
+			if isArray {
+				path += "[" + strconv.Itoa(index) + "]"
+				continue
+			}
+		}
+		path = joinSubDocPath(path, token)
+	}
+	return path
+}
+
+func arrayIndex(token string) (int, bool) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	index, err := strconv.Atoi(token)
+	return index, err == nil
+}
+
+func validatePathValueCount(pathValues []PathValue) error {
+	if len(pathValues) == 0 {
+		return errors.New("no write operations")
+	}
+	if len(pathValues) > maxSubDocOps {
+		return fmt.Errorf("%v operations exceed the sub-document limit of %v", len(pathValues), maxSubDocOps)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package couchbase
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
+)
+
+// formatPathValues renders path values as `op path value` lines, the upsert op is shown as Upsert.
+func formatPathValues(pathValues []PathValue) string {
+	lines := make([]string, 0, len(pathValues))
+	for _, pv := range pathValues {
+		op := pv.Op
+		if op == PathUpsert {
+			op = "Upsert"
+		}
+		line := fmt.Sprintf("%s %s", op, pv.Path)
+		if pv.Value != nil {
+			line += " " + string(pv.Value)
+		}
+		if pv.CreateParents {
+			line += " +parents"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestNewMergePatchAction(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+		err   string
+	}{
+		{
+			name:  "set, nested merge and remove",
+			patch: `{"name":"mug","price":{"amount":10,"old":null},"tags":["a"],"internal":null}`,
+			want: "Remove internal\nUpsert name \"mug\" +parents\nUpsert price.amount 10 +parents\n" +
+				"Remove price.old\nUpsert tags [\"a\"] +parents",
+		},
+		{name: "special characters are quoted", patch: `{"a.b":1}`, want: "Upsert `a.b` 1 +parents"},
+		{name: "large numbers keep their precision", patch: `{"id":12345678901234567890}`, want: "Upsert id 12345678901234567890 +parents"},
+		{name: "empty patch is a no-op", patch: `{}`},
+		{name: "empty objects are ignored", patch: `{"a":{},"b":1}`, want: "Upsert b 1 +parents"},
+		{name: "patch of empty objects is a no-op", patch: `{"a":{"b":{}}}`},
+		{name: "invalid json", patch: `{`, err: "merge patch"},
+		{
+			name:  "too many operations",
+			patch: `{"a":1,"b":1,"c":1,"d":1,"e":1,"f":1,"g":1,"h":1,"i":1,"j":1,"k":1,"l":1,"m":1,"n":1,"o":1,"p":1,"q":1}`,
+			err:   "17 operations exceed the sub-document limit of 16",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := NewMergePatchAction([]byte("doc"), []byte(tt.patch))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if actions != nil {
+					t.Fatalf("expected no action, got %+v", actions)
+				}
+				return
+			}
+			if len(actions) != 1 {
+				t.Fatalf("expected 1 action, got %+v", actions)
+			}
+			action := actions[0]
+			if action.Type != MultiMutateIn || !action.StrictPaths {
+				t.Fatalf("expected a strict MultiMutateIn, got %v strict %v", action.Type, action.StrictPaths)
+			}
+			if got := formatPathValues(action.PathValues); got != tt.want {
+				t.Fatalf("expected\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewMergePatchAction_NotAnObject(t *testing.T) {
+	actions, err := NewMergePatchAction([]byte("doc"), []byte(`[1,2]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Type != Set || string(actions[0].Source) != `[1,2]` {
+		t.Fatalf("expected the document to be replaced, got %+v", actions)
+	}
+}
+
+var jsonPatchTests = []struct {
+	name  string
+	patch string
+	base  string
+	want  string
+	err   string
+}{
+	{name: "add", patch: `[{"op":"add","path":"/name","value":"mug"}]`, want: `Upsert name "mug"`},
+	{name: "add to the end of an array", patch: `[{"op":"add","path":"/tags/-","value":"a"}]`, want: `ArrayPushLast tags "a"`},
+	{name: "add at an array index", patch: `[{"op":"add","path":"/tags/1","value":"a"}]`, want: `ArrayInsert tags[1] "a"`},
+	{name: "remove", patch: `[{"op":"remove","path":"/a~1b/c~0d"}]`, want: "Remove a/b.c~d"},
+	{name: "replace", patch: `[{"op":"replace","path":"/price","value":10}]`, want: "Replace price 10"},
+	{
+		name:  "numeric field of an object in the base",
+		patch: `[{"op":"replace","path":"/stock/1","value":3}]`,
+		base:  `{"stock":{"1":2}}`,
+		want:  "Replace stock.1 3",
+	},
+	{name: "move", patch: `[{"op":"move","from":"/a","path":"/b"}]`, err: `"move" is not supported`},
+	{name: "copy", patch: `[{"op":"copy","from":"/a","path":"/b"}]`, err: `"copy" is not supported`},
+	{name: "unknown op", patch: `[{"op":"merge","path":"/a"}]`, err: `unknown op "merge"`},
+	{name: "missing path", patch: `[{"op":"remove"}]`, err: "missing path"},
+	{name: "missing value", patch: `[{"op":"add","path":"/a"}]`, err: "missing value"},
+	{name: "invalid pointer", patch: `[{"op":"remove","path":"a"}]`, err: `invalid json pointer "a"`},
+	{name: "whole document", patch: `[{"op":"replace","path":"","value":{}}]`, err: `"replace" on the whole document`},
+	{name: "test only", patch: `[{"op":"test","path":"/a","value":1}]`, base: `{"a":1}`, err: "no write operations"},
+	{name: "test without base", patch: `[{"op":"test","path":"/a","value":1}]`, err: "need the base document"},
+	{
+		name:  "failing test",
+		patch: `[{"op":"test","path":"/a","value":"1"},{"op":"remove","path":"/a"}]`,
+		base:  `{"a":1}`,
+		err:   `test failed at "/a"`,
+	},
+	{
+		name:  "test after a write",
+		patch: `[{"op":"remove","path":"/b"},{"op":"test","path":"/a","value":1}]`,
+		base:  `{"a":1,"b":2}`,
+		err:   "test operations must precede the other operations",
+	},
+}
+
+func TestNewJSONPatchAction(t *testing.T) {
+	for _, tt := range jsonPatchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var base *GetResult
+			if tt.base != "" {
+				base = &GetResult{Value: []byte(tt.base), Cas: 7}
+			}
+
+			action, err := NewJSONPatchAction([]byte("doc"), []byte(tt.patch), base)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if action.Type != MultiMutateIn || !action.StrictPaths {
+				t.Fatalf("expected a strict MultiMutateIn, got %v strict %v", action.Type, action.StrictPaths)
+			}
+			if got := formatPathValues(action.PathValues); got != tt.want {
+				t.Fatalf("expected\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewJSONPatchAction_TestSetsCas(t *testing.T) {
+	patch := `[{"op":"test","path":"/items/0/id","value":1},{"op":"replace","path":"/items/0/qty","value":2}]`
+	base := &GetResult{Value: []byte(`{"items":[{"id":1,"qty":1}]}`), Cas: 42}
+
+	action, err := NewJSONPatchAction([]byte("doc"), []byte(patch), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action.Cas == nil || *action.Cas != 42 {
+		t.Fatalf("expected the cas of the base document, got %v", action.Cas)
+	}
+	if got := formatPathValues(action.PathValues); got != "Replace items[0].qty 2" {
+		t.Fatalf("unexpected path values %s", got)
+	}
+
+	action, err = NewJSONPatchAction([]byte("doc"), []byte(`[{"op":"remove","path":"/a"}]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action.Cas != nil {
+		t.Fatalf("expected no cas without a base document, got %v", *action.Cas)
+	}
+}
+
+func TestIgnoredStatus_StrictPaths(t *testing.T) {
+	badMulti := &gocbcore.KeyValueError{StatusCode: memd.StatusSubDocBadMulti}
+	keyNotFound := &gocbcore.KeyValueError{StatusCode: memd.StatusKeyNotFound}
+
+	actions, err := NewMergePatchAction([]byte("doc"), []byte(`{"a":{"b":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	action := actions[0]
+	if _, ok := ignoredStatus(&action, badMulti); ok {
+		t.Fatal("expected bad_multi of a patch to be an error")
+	}
+	if status, ok := ignoredStatus(&action, keyNotFound); !ok || status != "key_not_found" {
+		t.Fatalf("expected key_not_found to be ignored, got %q %v", status, ok)
+	}
+
+	action = NewMultiMutateInAction([]byte("doc"), []PathValue{{Path: []byte("a")}})
+	if status, ok := ignoredStatus(&action, badMulti); !ok || status != "bad_multi" {
+		t.Fatalf("expected bad_multi to be ignored by default, got %q %v", status, ok)
+	}
+}
@@ -0,0 +1,52 @@
+package couchbase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// Ping checks the key value connections of the target bucket, it fails if one of the nodes does not answer.
+func Ping(ctx context.Context, client Client) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+
+	ch := make(chan error, 1)
+	_, err := client.GetAgent().Ping(gocbcore.PingOptions{
+		KVDeadline:   deadline,
+		ServiceTypes: []gocbcore.ServiceType{gocbcore.MemdService},
+	}, func(result *gocbcore.PingResult, err error) {
+		if err == nil {
+			err = pingError(result)
+		}
+		ch <- err
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func pingError(result *gocbcore.PingResult) error {
+	endpoints := result.Services[gocbcore.MemdService]
+	if len(endpoints) == 0 {
+		return fmt.Errorf("no key value endpoint")
+	}
+
+	for _, endpoint := range endpoints {
+		if endpoint.State != gocbcore.PingStateOK {
+			return fmt.Errorf("endpoint %v: %v", endpoint.Endpoint, endpoint.Error)
+		}
+	}
+	return nil
+}
@@ -3,38 +3,60 @@ package couchbase
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Trendyol/go-dcp/helpers"
 
 	"github.com/Trendyol/go-dcp/logger"
 
-	"github.com/couchbase/gocbcore/v10/memd"
-
 	"github.com/Trendyol/go-dcp-couchbase/config"
 
 	"github.com/Trendyol/go-dcp/models"
 	"github.com/couchbase/gocbcore/v10"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/time/rate"
 )
 
 type Processor struct {
 	sinkResponseHandler SinkResponseHandler
 	targetClient        TargetClient
+	targetClientCache   *CachedTargetClient
 	client              Client
+	spool               *Spool
 	metric              *Metric
+	histograms          *processorHistograms
+	tracer              trace.Tracer
+	pauseGate           *pauseGate
+	writeLimiter        *rate.Limiter
+	actionCounter       *actionCounter
+	writtenSeqNos       *vbucketSeqNos
 	dcpCheckpointCommit func()
 	inflightCh          chan struct{}
 	batchTicker         *time.Ticker
 	batch               []CBActionDocument
+	scopeName           string
+	collectionName      string
 	requestTimeout      time.Duration
 	batchTickerDuration time.Duration
-	batchByteSizeLimit  int
+	spoolReplayInterval time.Duration
+	batchByteSizeLimit  atomic.Int64
 	batchByteSize       int
-	batchSizeLimit      int
+	batchSizeLimit      atomic.Int64
 	batchSize           int
 	flushLock           sync.Mutex
-	isDcpRebalancing    bool
+	bufferedActions     atomic.Int64
+	bufferedByteSize    atomic.Int64
+	oldestEventTime     atomic.Int64
+	lastFlushTime       atomic.Int64
+	lastProgressTime    atomic.Int64
+	isTargetUnavailable atomic.Bool
+	isDcpRebalancing    atomic.Bool
+	invalidateOnWrite   bool
 }
 
 type Metric struct {
@@ -54,61 +76,123 @@ func NewProcessor(
 	processor := &Processor{
 		client:              client,
 		requestTimeout:      config.Couchbase.RequestTimeout,
+		scopeName:           config.Couchbase.ScopeName,
+		collectionName:      config.Couchbase.CollectionName,
 		dcpCheckpointCommit: dcpCheckpointCommit,
 		metric:              &Metric{},
+		histograms:          newProcessorHistograms(config.Couchbase.Metric.Histogram),
+		tracer:              noop.NewTracerProvider().Tracer(tracerName),
+		actionCounter:       newActionCounter(config.Couchbase.CollectionName),
+		writtenSeqNos:       &vbucketSeqNos{seqNos: map[uint16]uint64{}},
 		sinkResponseHandler: sinkResponseHandler,
 		targetClient:        targetClient,
 		inflightCh:          make(chan struct{}, config.Couchbase.MaxInflightRequests),
 		batchTicker:         time.NewTicker(config.Couchbase.BatchTickerDuration),
-		batchSizeLimit:      config.Couchbase.BatchSizeLimit,
-		batchByteSizeLimit:  helpers.ResolveUnionIntOrStringValue(config.Couchbase.BatchByteSizeLimit),
+		pauseGate:           newPauseGate(),
+		writeLimiter:        newWriteLimiter(config.Couchbase.WriteRateLimit),
 		batchTickerDuration: config.Couchbase.BatchTickerDuration,
 	}
 
+	if cache, ok := targetClient.(*CachedTargetClient); ok {
+		processor.targetClientCache = cache
+		processor.invalidateOnWrite = config.Couchbase.TargetClientCache.InvalidateOnWrite
+	}
+
+	if config.Couchbase.Spool.Enabled {
+		spool, err := OpenSpool(&config.Couchbase.Spool)
+		if err != nil {
+			return nil, err
+		}
+		processor.spool = spool
+		processor.spoolReplayInterval = config.Couchbase.Spool.ReplayInterval
+	}
+
+	processor.batchSizeLimit.Store(int64(config.Couchbase.BatchSizeLimit))
+	processor.batchByteSizeLimit.Store(int64(helpers.ResolveUnionIntOrStringValue(config.Couchbase.BatchByteSizeLimit)))
+	processor.lastFlushTime.Store(time.Now().UnixNano())
+	processor.markProgress()
+
 	return processor, nil
 }
 
+// SetTracerProvider records the spans of events, flushes, actions, retries and sink response handler calls
+// with tp, it is called before StartProcessor.
+func (b *Processor) SetTracerProvider(tp trace.TracerProvider) {
+	b.tracer = tp.Tracer(tracerName)
+}
+
 func (b *Processor) StartProcessor() {
+	if b.spool != nil {
+		go b.replaySpool()
+	}
 	for range b.batchTicker.C {
 		b.flushMessages()
 	}
 }
 
+// Close writes the batch, a paused processor keeps it unwritten and uncommitted.
 func (b *Processor) Close() {
 	b.batchTicker.Stop()
+	b.pauseGate.close()
 	b.flushMessages()
+	if b.spool != nil {
+		if err := b.spool.Close(); err != nil {
+			logger.Log.Error("error while close spool, err: %v", err)
+		}
+	}
 	b.client.Close()
 }
 
 func (b *Processor) flushMessages() {
 	b.flushLock.Lock()
 	defer b.flushLock.Unlock()
-	if b.isDcpRebalancing {
+	if b.isDcpRebalancing.Load() || b.IsPaused() {
 		return
 	}
+	// an empty tick only counts as a flush when nothing waits in the spool either
+	written := !b.shouldSpool()
 	if len(b.batch) > 0 {
-		b.bulkRequest()
+		if written {
+			b.bulkRequest()
+			written = !b.isTargetUnavailable.Load()
+		} else {
+			b.spoolActions(b.batch)
+		}
 		b.batchTicker.Reset(b.batchTickerDuration)
-		b.batch = b.batch[:0]
-		b.batchSize = 0
-		b.batchByteSize = 0
+		b.resetBatch()
 	}
+	if written {
+		b.lastFlushTime.Store(time.Now().UnixNano())
+	}
+	// the batch is written or spooled
+	b.markProgress()
 	b.dcpCheckpointCommit()
 }
 
 func (b *Processor) PrepareStartRebalancing() {
+	b.pauseGate.interrupt()
 	b.flushLock.Lock()
 	defer b.flushLock.Unlock()
-	b.isDcpRebalancing = true
+	b.isDcpRebalancing.Store(true)
+	b.markProgress()
+	b.resetBatch()
+}
+
+// resetBatch empties the batch, it is called under flushLock.
+func (b *Processor) resetBatch() {
 	b.batch = b.batch[:0]
 	b.batchSize = 0
 	b.batchByteSize = 0
+	b.bufferedActions.Store(0)
+	b.bufferedByteSize.Store(0)
+	b.oldestEventTime.Store(0)
 }
 
 func (b *Processor) PrepareEndRebalancing() {
 	b.flushLock.Lock()
 	defer b.flushLock.Unlock()
-	b.isDcpRebalancing = false
+	b.isDcpRebalancing.Store(false)
+	b.markProgress()
 }
 
 func (b *Processor) AddActions(
@@ -117,60 +201,174 @@ func (b *Processor) AddActions(
 	actions []CBActionDocument,
 	isLastChunk bool,
 ) {
+	origin := newEventOrigin(ctx, eventTime)
+	for i := range actions {
+		actions[i].origin = origin
+	}
+
 	b.flushLock.Lock()
 	b.batch = append(b.batch, actions...)
 	b.batchSize += len(actions)
 	for _, action := range actions {
 		b.batchByteSize += action.Size
 	}
+	b.bufferedActions.Store(int64(b.batchSize))
+	b.bufferedByteSize.Store(int64(b.batchByteSize))
+	if oldest := b.oldestEventTime.Load(); len(actions) > 0 && (oldest == 0 || eventTime.UnixNano() < oldest) {
+		b.oldestEventTime.Store(eventTime.UnixNano())
+	}
 	if isLastChunk {
 		ctx.Ack()
 	}
+	isBatchFull := int64(b.batchSize) >= b.batchSizeLimit.Load() || int64(b.batchByteSize) >= b.batchByteSizeLimit.Load()
 	b.flushLock.Unlock()
 
 	if isLastChunk {
-		b.metric.ProcessLatencyMs = time.Since(eventTime).Milliseconds()
+		atomic.StoreInt64(&b.metric.ProcessLatencyMs, time.Since(eventTime).Milliseconds())
 	}
-	if b.batchSize >= b.batchSizeLimit || b.batchByteSize >= b.batchByteSizeLimit {
+	if isBatchFull {
+		b.pauseGate.wait()
 		b.flushMessages()
 	}
 }
 
+// GetMetric returns a snapshot of the latest latency and batch size values.
 func (b *Processor) GetMetric() *Metric {
-	return b.metric
+	return &Metric{
+		ProcessLatencyMs:            atomic.LoadInt64(&b.metric.ProcessLatencyMs),
+		BulkRequestProcessLatencyMs: atomic.LoadInt64(&b.metric.BulkRequestProcessLatencyMs),
+		BulkRequestSize:             atomic.LoadInt64(&b.metric.BulkRequestSize),
+		BulkRequestByteSize:         atomic.LoadInt64(&b.metric.BulkRequestByteSize),
+	}
 }
 
-func (b *Processor) panicOrGo(action *CBActionDocument, err error) {
-	isRequestSuccessful := err == nil
+// GetLagMetric returns the buffered backlog and the last sequence number written per vbucket.
+func (b *Processor) GetLagMetric() LagMetric {
+	now := time.Now()
 
-	var kvErr *gocbcore.KeyValueError
-	if errors.As(err, &kvErr) && (kvErr.StatusCode == memd.StatusKeyNotFound ||
-		kvErr.StatusCode == memd.StatusSubDocPathNotFound ||
-		kvErr.StatusCode == memd.StatusSubDocBadMulti ||
-		kvErr.StatusCode == memd.StatusSubDocMultiPathFailureDeleted) {
-		isRequestSuccessful = true
+	m := LagMetric{
+		LastWrittenSeqNos: b.writtenSeqNos.snapshot(),
+		BufferedActions:   b.bufferedActions.Load(),
+		BufferedByteSize:  b.bufferedByteSize.Load(),
+		SinceLastFlush:    now.Sub(time.Unix(0, b.lastFlushTime.Load())),
 	}
+	if oldest := b.oldestEventTime.Load(); oldest > 0 {
+		m.OldestUnflushedAge = now.Sub(time.Unix(0, oldest))
+	}
+	return m
+}
+
+// SinceLastProgress is the time since a batch was last written or spooled, or a rebalance started or ended.
+func (b *Processor) SinceLastProgress() time.Duration {
+	return time.Since(time.Unix(0, b.lastProgressTime.Load()))
+}
+
+func (b *Processor) markProgress() {
+	b.lastProgressTime.Store(time.Now().UnixNano())
+}
+
+// IsTargetUnavailable reports whether writes are spooled because the target bucket is unavailable.
+func (b *Processor) IsTargetUnavailable() bool {
+	return b.isTargetUnavailable.Load()
+}
+
+// IsRebalancing reports whether the batch is held back by a DCP rebalance.
+func (b *Processor) IsRebalancing() bool {
+	return b.isDcpRebalancing.Load()
+}
+
+// GetHistograms returns the event, action and flush latency histograms.
+func (b *Processor) GetHistograms() []prometheus.Collector {
+	return b.histograms.collectors()
+}
+
+func (b *Processor) GetTargetClientCache() *CachedTargetClient {
+	return b.targetClientCache
+}
 
-	if isRequestSuccessful {
-		b.handleSuccess(action)
+// invalidateCache drops the written document from the target client cache, only actions on the
+// configured collection are cached since the target client reads from it.
+func (b *Processor) invalidateCache(action *CBActionDocument) {
+	if !b.invalidateOnWrite {
 		return
 	}
+	if action.CollectionName != "" && action.CollectionName != b.collectionName {
+		return
+	}
+	b.targetClientCache.Invalidate(action.ID)
+}
 
-	b.handleError(action, err)
+func (b *Processor) panicOrGo(ctx context.Context, action *CBActionDocument, err error) {
+	b.invalidateCache(action)
+
+	if err == nil {
+		b.actionCounter.inc(action, ActionOutcomeSuccess)
+		b.updateWrittenSeqNo(action)
+		action.written(nil)
+		b.handleSuccess(ctx, action)
+		return
+	}
+
+	if status, ok := ignoredStatus(action, err); ok {
+		b.actionCounter.inc(action, ActionOutcomeIgnored)
+		b.actionCounter.incIgnored(status)
+		b.updateWrittenSeqNo(action)
+		action.written(nil)
+		b.handleSuccess(ctx, action)
+		return
+	}
+
+	b.actionCounter.inc(action, ActionOutcomeError)
+	action.written(err)
+	if !action.BestEffort {
+		b.handleError(ctx, action, err)
+	}
+}
+
+func (b *Processor) updateWrittenSeqNo(action *CBActionDocument) {
+	if action.origin.seqNo > 0 {
+		b.writtenSeqNos.update(action.origin.vbID, action.origin.seqNo)
+	}
 }
 
-func (b *Processor) handleError(action *CBActionDocument, err error) {
+func (b *Processor) handleError(ctx context.Context, action *CBActionDocument, err error) {
 	if b.sinkResponseHandler == nil {
 		logger.Log.Error("error while write, err: %v", err)
 		panic(err)
 	}
 
+	ctx, span := b.startActionSpan(ctx, "couchbase.sinkResponseHandler.OnError", action)
+	defer span.End()
+
 	s := &SinkResponseHandlerContext{
 		Action:       action,
 		Err:          err,
 		TargetClient: b.targetClient,
 	}
-	s.Retry = func(ctx context.Context) error {
+	s.Retry = b.retry(ctx, s)
+	b.sinkResponseHandler.OnError(s)
+}
+
+func (b *Processor) handleSuccess(ctx context.Context, action *CBActionDocument) {
+	if b.sinkResponseHandler != nil {
+		ctx, span := b.startActionSpan(ctx, "couchbase.sinkResponseHandler.OnSuccess", action)
+		defer span.End()
+
+		s := &SinkResponseHandlerContext{
+			Action:       action,
+			TargetClient: b.targetClient,
+		}
+		s.Retry = b.retry(ctx, s)
+		b.sinkResponseHandler.OnSuccess(s)
+	}
+}
+
+// retry executes the action again, its span is a child of the handler call span.
+func (b *Processor) retry(handlerCtx context.Context, s *SinkResponseHandlerContext) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		b.actionCounter.inc(s.Action, ActionOutcomeRetried)
+
+		ctx, span := b.startActionSpan(trace.ContextWithSpan(ctx, trace.SpanFromContext(handlerCtx)), "couchbase.retry", s.Action)
 		errCh := make(chan error, 1)
 
 		b.inflightCh <- struct{}{}
@@ -179,54 +377,268 @@ func (b *Processor) handleError(action *CBActionDocument, err error) {
 			<-b.inflightCh
 		})
 
-		return <-errCh
+		err := <-errCh
+		endSpan(span, err)
+		return err
 	}
-	b.sinkResponseHandler.OnError(s)
 }
 
-func (b *Processor) handleSuccess(action *CBActionDocument) {
-	if b.sinkResponseHandler != nil {
-		s := &SinkResponseHandlerContext{
-			Action:       action,
-			TargetClient: b.targetClient,
-		}
-		s.Retry = func(ctx context.Context) error {
-			errCh := make(chan error, 1)
+// GetActionCounts returns the count of written actions by type, target collection and outcome.
+func (b *Processor) GetActionCounts() map[ActionCountKey]int64 {
+	return b.actionCounter.snapshot()
+}
 
-			b.inflightCh <- struct{}{}
-			b.client.Execute(ctx, s.Action, func(err error) {
-				errCh <- err
-				<-b.inflightCh
-			})
+// GetIgnoredStatusCounts returns the count of actions whose error status was handled as success, by status.
+func (b *Processor) GetIgnoredStatusCounts() map[string]int64 {
+	return b.actionCounter.ignoredSnapshot()
+}
 
-			return <-errCh
-		}
-		b.sinkResponseHandler.OnSuccess(s)
+// handleResponse reports whether the action was spooled since the target is unavailable.
+func (b *Processor) handleResponse(
+	ctx context.Context, span trace.Span, idx int, wg *sync.WaitGroup, unavailable *spooledActions, err error,
+) bool {
+	defer wg.Done()
+	defer endSpan(span, err)
+
+	if b.spool != nil && isTargetUnavailableError(err) {
+		span.SetAttributes(attributeSpooled.Bool(true))
+		unavailable.add(idx)
+		return true
 	}
-}
 
-func (b *Processor) handleResponse(idx int, wg *sync.WaitGroup, err error) {
-	b.panicOrGo(&b.batch[idx], err)
-	wg.Done()
+	action := &b.batch[idx]
+	if !action.origin.time.IsZero() {
+		b.histograms.eventLatency.Observe(time.Since(action.origin.time).Seconds())
+	}
+	b.panicOrGo(ctx, action, err)
+	return false
 }
 
+// bulkRequest writes the actions of different documents concurrently and the actions of a document in batch order.
 func (b *Processor) bulkRequest() {
 	startedTime := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), b.requestTimeout)
-	defer cancel()
+	ctx, flushSpan := b.tracer.Start(context.Background(), "couchbase.flush", trace.WithAttributes(
+		attributeActionCount.Int(b.batchSize),
+		attributeByteSize.Int(b.batchByteSize),
+	))
+	defer flushSpan.End()
 	var wg sync.WaitGroup
+	var unavailable spooledActions
 	wg.Add(len(b.batch))
-	for i := 0; i < len(b.batch); i++ {
-		func(idx int) {
-			b.inflightCh <- struct{}{}
-			b.client.Execute(ctx, &b.batch[idx], func(err error) {
-				go b.handleResponse(idx, &wg, err)
-				<-b.inflightCh
-			})
-		}(i)
+	for _, chain := range b.documentChains() {
+		b.executeChain(ctx, chain, &wg, &unavailable)
 	}
 	wg.Wait()
-	b.metric.BulkRequestProcessLatencyMs = time.Since(startedTime).Milliseconds()
-	b.metric.BulkRequestSize = int64(b.batchSize)
-	b.metric.BulkRequestByteSize = int64(b.batchByteSize)
+	if len(unavailable.indexes) > 0 {
+		flushSpan.SetAttributes(attributeSpooled.Int(len(unavailable.indexes)))
+		b.isTargetUnavailable.Store(true)
+		logger.Log.Warn("target is unavailable, spooling %v actions", len(unavailable.indexes))
+		b.spoolActions(unavailable.actions(b.batch))
+	}
+	latency := time.Since(startedTime)
+	b.histograms.flushLatency.Observe(latency.Seconds())
+	atomic.StoreInt64(&b.metric.BulkRequestProcessLatencyMs, latency.Milliseconds())
+	atomic.StoreInt64(&b.metric.BulkRequestSize, int64(b.batchSize))
+	atomic.StoreInt64(&b.metric.BulkRequestByteSize, int64(b.batchByteSize))
+}
+
+// documentChains groups the batch indexes by target document in batch order.
+func (b *Processor) documentChains() [][]int {
+	chainIndexes := make(map[string]int, len(b.batch))
+	chains := make([][]int, 0, len(b.batch))
+	for i := range b.batch {
+		action := &b.batch[i]
+		scopeName, collectionName := action.ScopeName, action.CollectionName
+		if scopeName == "" {
+			scopeName = b.scopeName
+		}
+		if collectionName == "" {
+			collectionName = b.collectionName
+		}
+		key := scopeName + "\x00" + collectionName + "\x00" + string(action.ID)
+
+		if chainIdx, ok := chainIndexes[key]; ok {
+			chains[chainIdx] = append(chains[chainIdx], i)
+			continue
+		}
+		chainIndexes[key] = len(chains)
+		chains = append(chains, []int{i})
+	}
+	return chains
+}
+
+// executeChain writes the first action of chain and the rest once it is done. After a spooled action the rest
+// is spooled without a write, so the replay does not overwrite a later write of the document.
+func (b *Processor) executeChain(ctx context.Context, chain []int, wg *sync.WaitGroup, unavailable *spooledActions) {
+	idx := chain[0]
+	b.inflightCh <- struct{}{}
+	b.waitWriteLimiter()
+	actionCtx, cancel := context.WithTimeout(ctx, b.requestTimeout)
+	actionCtx, span := b.startActionSpan(actionCtx, "couchbase."+string(b.batch[idx].Type), &b.batch[idx])
+	executedTime := time.Now()
+	b.client.Execute(actionCtx, &b.batch[idx], func(err error) {
+		b.histograms.actionLatency.WithLabelValues(string(b.batch[idx].Type)).Observe(time.Since(executedTime).Seconds())
+		go func() {
+			spooled := b.handleResponse(actionCtx, span, idx, wg, unavailable, err)
+			cancel()
+			switch {
+			case len(chain) == 1:
+			case spooled:
+				for _, next := range chain[1:] {
+					unavailable.add(next)
+					wg.Done()
+				}
+			default:
+				b.executeChain(ctx, chain[1:], wg, unavailable)
+			}
+		}()
+		<-b.inflightCh
+	})
+}
+
+type spooledActions struct {
+	indexes []int
+	mu      sync.Mutex
+}
+
+func (s *spooledActions) add(idx int) {
+	s.mu.Lock()
+	s.indexes = append(s.indexes, idx)
+	s.mu.Unlock()
+}
+
+// actions returns the spooled actions in batch order.
+func (s *spooledActions) actions(batch []CBActionDocument) []CBActionDocument {
+	sort.Ints(s.indexes)
+	actions := make([]CBActionDocument, 0, len(s.indexes))
+	for _, idx := range s.indexes {
+		actions = append(actions, batch[idx])
+	}
+	return actions
+}
+
+// shouldSpool keeps writes in order, once an action is spooled every new batch goes
+// behind it until the replay drains the spool.
+func (b *Processor) shouldSpool() bool {
+	return b.spool != nil && (b.isTargetUnavailable.Load() || b.spool.Len() > 0)
+}
+
+// spoolActions blocks while the spool is full, so the checkpoint is never committed
+// for actions which are neither written nor spooled.
+func (b *Processor) spoolActions(actions []CBActionDocument) {
+	for i := range actions {
+		b.actionCounter.inc(&actions[i], ActionOutcomeSpooled)
+	}
+	b.appendToSpool(actions)
+}
+
+// appendToSpool splits actions which do not fit into the empty spool in halves, their order is kept.
+func (b *Processor) appendToSpool(actions []CBActionDocument) {
+	for {
+		err := b.spool.Append(actions)
+		switch {
+		case err == nil:
+			for i := range actions {
+				actions[i].written(nil)
+			}
+			b.markProgress()
+			return
+		case errors.Is(err, ErrSpoolTooSmall) && len(actions) > 1:
+			half := len(actions) / 2
+			b.appendToSpool(actions[:half])
+			b.appendToSpool(actions[half:])
+			return
+		case !errors.Is(err, ErrSpoolFull):
+			logger.Log.Error("error while append to spool, err: %v", err)
+			panic(err)
+		}
+
+		logger.Log.Warn("spool is full, waiting %v for replay", b.spoolReplayInterval)
+		time.Sleep(b.spoolReplayInterval)
+	}
+}
+
+func (b *Processor) replaySpool() {
+	ticker := time.NewTicker(b.spoolReplayInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for b.replaySpoolBatch() {
+		}
+	}
+}
+
+// replaySpoolBatch writes the oldest spooled actions one by one to keep their order,
+// it returns false when the spool is drained or the target is still unavailable.
+func (b *Processor) replaySpoolBatch() bool {
+	entries, err := b.spool.read(int(b.batchSizeLimit.Load()))
+	if err != nil {
+		logger.Log.Error("error while read spool, err: %v", err)
+		return false
+	}
+
+	if len(entries) == 0 {
+		b.isTargetUnavailable.Store(false)
+		return false
+	}
+
+	replayed := 0
+	for i := range entries {
+		if entries[i].corrupted {
+			logger.Log.Warn("skipping corrupted spool record, segment: %v, offset: %v, size: %v",
+				entries[i].position.segment, entries[i].position.offset, entries[i].size)
+			replayed++
+			continue
+		}
+		err := b.executeSync(&entries[i].action)
+		if isTargetUnavailableError(err) {
+			b.isTargetUnavailable.Store(true)
+			break
+		}
+		b.panicOrGo(context.Background(), &entries[i].action, err)
+		replayed++
+	}
+
+	if err := b.spool.advance(entries[:replayed]); err != nil {
+		logger.Log.Error("error while advance spool, err: %v", err)
+		panic(err)
+	}
+
+	if replayed > 0 {
+		b.lastFlushTime.Store(time.Now().UnixNano())
+		b.markProgress()
+	}
+	if replayed < len(entries) {
+		return false
+	}
+
+	logger.Log.Debug("replayed %v actions from spool", replayed)
+	return true
+}
+
+func (b *Processor) executeSync(action *CBActionDocument) error {
+	b.waitWriteLimiter()
+	ctx, cancel := context.WithTimeout(context.Background(), b.requestTimeout)
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	b.inflightCh <- struct{}{}
+	b.client.Execute(ctx, action, func(err error) {
+		errCh <- err
+		<-b.inflightCh
+	})
+
+	return <-errCh
+}
+
+func isTargetUnavailableError(err error) bool {
+	return errors.Is(err, gocbcore.ErrTimeout) ||
+		errors.Is(err, gocbcore.ErrRequestCanceled) ||
+		errors.Is(err, gocbcore.ErrServiceNotAvailable) ||
+		errors.Is(err, gocbcore.ErrTemporaryFailure) ||
+		errors.Is(err, gocbcore.ErrOverload) ||
+		errors.Is(err, gocbcore.ErrBusy) ||
+		errors.Is(err, gocbcore.ErrShutdown) ||
+		errors.Is(err, gocbcore.ErrSocketClosed) ||
+		errors.Is(err, gocbcore.ErrMemdClientClosed)
 }
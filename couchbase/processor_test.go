@@ -0,0 +1,270 @@
+package couchbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Trendyol/go-dcp/models"
+	"github.com/couchbase/gocbcore/v10"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+)
+
+func TestProcessor_BestEffortWriteError(t *testing.T) {
+	b := &Processor{actionCounter: newActionCounter("collection")}
+
+	var written []error
+	action := NewSetAction([]byte("doc"), []byte(`{}`))
+	action.SetBestEffort(true)
+	action.AddWrittenCallback(func(err error) { written = append(written, err) })
+	action.AddWrittenCallback(func(err error) { written = append(written, err) })
+
+	writeErr := errors.New("write failed")
+	b.panicOrGo(context.Background(), &action, writeErr)
+
+	if len(written) != 2 || written[0] != writeErr || written[1] != writeErr {
+		t.Fatalf("expected both callbacks to get the write error, got %v", written)
+	}
+	key := ActionCountKey{ActionType: Set, CollectionName: "collection", Outcome: ActionOutcomeError}
+	if count := b.actionCounter.snapshot()[key]; count != 1 {
+		t.Fatalf("expected the write error to be counted, got %d", count)
+	}
+}
+
+func TestProcessor_WriteErrorWithoutHandler(t *testing.T) {
+	b := &Processor{actionCounter: newActionCounter("collection")}
+	action := NewSetAction([]byte("doc"), []byte(`{}`))
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a write error without a sink response handler to panic")
+		}
+	}()
+	b.panicOrGo(context.Background(), &action, errors.New("write failed"))
+}
+
+// fakeClient executes actions with the result of results by source value, an action without result succeeds.
+type fakeClient struct {
+	Client
+	results  map[string]error
+	executed []string
+	mu       sync.Mutex
+}
+
+func (c *fakeClient) Execute(_ context.Context, action *CBActionDocument, callback func(err error)) {
+	c.mu.Lock()
+	c.executed = append(c.executed, string(action.Source))
+	err := c.results[string(action.Source)]
+	c.mu.Unlock()
+	go callback(err)
+}
+
+func newTestProcessor(t *testing.T, client Client) *Processor {
+	t.Helper()
+
+	c := &config.Config{Couchbase: config.Couchbase{
+		Hosts:      []string{"localhost:8091"},
+		BucketName: "bucket",
+		Spool:      config.Spool{Enabled: true, Dir: t.TempDir()},
+	}}
+	c.ApplyDefaults()
+	discardLogs(t)
+
+	b, err := NewProcessor(c, client, func() {}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.spool.Close() })
+	return b
+}
+
+func TestProcessor_BulkRequestSpoolsLaterWritesOfADocument(t *testing.T) {
+	client := &fakeClient{results: map[string]error{"a1": gocbcore.ErrTimeout}}
+	b := newTestProcessor(t, client)
+
+	b.batch = []CBActionDocument{
+		NewSetAction([]byte("a"), []byte("a1")),
+		NewSetAction([]byte("b"), []byte("b1")),
+		NewSetAction([]byte("a"), []byte("a2")),
+		NewSetAction([]byte("b"), []byte("b2")),
+		NewSetAction([]byte("a"), []byte("a3")),
+	}
+	b.batchSize = len(b.batch)
+	b.bulkRequest()
+
+	sort.Strings(client.executed)
+	if fmt.Sprint(client.executed) != "[a1 b1 b2]" {
+		t.Fatalf("expected the writes after a1 to wait, executed %v", client.executed)
+	}
+	entries, err := b.spool.read(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spooled []string
+	for _, entry := range entries {
+		spooled = append(spooled, string(entry.action.Source))
+	}
+	if fmt.Sprint(spooled) != "[a1 a2 a3]" {
+		t.Fatalf("expected the writes of a to be spooled in order, got %v", spooled)
+	}
+}
+
+// overlapClient fails the test when a write of a document starts before the previous one finished.
+type overlapClient struct {
+	fakeClient
+	t        *testing.T
+	inflight map[string]bool
+}
+
+func (c *overlapClient) Execute(ctx context.Context, action *CBActionDocument, callback func(err error)) {
+	c.mu.Lock()
+	if c.inflight[string(action.ID)] {
+		c.t.Errorf("%s was executed while a write of %s is in flight", action.Source, action.ID)
+	}
+	c.inflight[string(action.ID)] = true
+	c.mu.Unlock()
+
+	c.fakeClient.Execute(ctx, action, func(err error) {
+		time.Sleep(time.Millisecond)
+		c.mu.Lock()
+		c.inflight[string(action.ID)] = false
+		c.mu.Unlock()
+		callback(err)
+	})
+}
+
+func TestProcessor_BulkRequestWritesADocumentInOrder(t *testing.T) {
+	client := &overlapClient{t: t, inflight: map[string]bool{}}
+	b := newTestProcessor(t, client)
+
+	// counters of a group and the tracker of one source document per event, like an aggregation view writes them
+	for i := range 3 {
+		tracker := NewMultiMutateInAction([]byte("tracker"), []PathValue{{Path: []byte("a"), Xattr: true}})
+		tracker.Source = []byte(fmt.Sprintf("tracker%v", i))
+		b.batch = append(b.batch, NewMultiMutateInAction([]byte(fmt.Sprintf("group%v", i)), nil), tracker)
+	}
+	b.batchSize = len(b.batch)
+	b.bulkRequest()
+
+	var trackers []string
+	for _, source := range client.executed {
+		if strings.HasPrefix(source, "tracker") {
+			trackers = append(trackers, source)
+		}
+	}
+	if fmt.Sprint(trackers) != "[tracker0 tracker1 tracker2]" {
+		t.Fatalf("expected the tracker writes in batch order, got %v", trackers)
+	}
+}
+
+func TestProcessor_RebalanceReleasesPausedAddActions(t *testing.T) {
+	b := newTestProcessor(t, &fakeClient{})
+	batchSizeLimit := 1
+	if err := b.SetLimits(LimitsUpdate{BatchSizeLimit: &batchSizeLimit}); err != nil {
+		t.Fatal(err)
+	}
+	b.Pause()
+
+	done := make(chan struct{})
+	go func() {
+		ctx := &models.ListenerContext{Ack: func() {}}
+		b.AddActions(ctx, time.Now(), []CBActionDocument{NewSetAction([]byte("doc"), []byte(`{}`))}, true)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected AddActions to wait while the processor is paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.PrepareStartRebalancing()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the rebalance to release AddActions")
+	}
+	if !b.IsPaused() {
+		t.Fatal("expected the processor to stay paused")
+	}
+}
+
+// linkRecordingTracer records the links of the started spans by span name.
+type linkRecordingTracer struct {
+	noop.Tracer
+	links map[string][]trace.Link
+	mu    sync.Mutex
+}
+
+func (t *linkRecordingTracer) Start(
+	ctx context.Context, name string, opts ...trace.SpanStartOption,
+) (context.Context, trace.Span) {
+	spanConfig := trace.NewSpanStartConfig(opts...)
+	t.mu.Lock()
+	t.links[name] = spanConfig.Links()
+	t.mu.Unlock()
+	return t.Tracer.Start(ctx, name, opts...)
+}
+
+type retryingSinkResponseHandler struct {
+	errs []error
+}
+
+func (h *retryingSinkResponseHandler) OnSuccess(_ *SinkResponseHandlerContext) {}
+
+func (h *retryingSinkResponseHandler) OnError(ctx *SinkResponseHandlerContext) {
+	h.errs = append(h.errs, ctx.Retry(context.Background()))
+}
+
+func TestProcessor_ActionSpansLinkToTheEvent(t *testing.T) {
+	client := &fakeClient{results: map[string]error{"a1": errors.New("write failed")}}
+	b := newTestProcessor(t, client)
+	tracer := &linkRecordingTracer{links: map[string][]trace.Link{}}
+	handler := &retryingSinkResponseHandler{}
+	b.tracer, b.sinkResponseHandler = tracer, handler
+
+	eventSpanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	action := NewSetAction([]byte("a"), []byte("a1"))
+	action.SetEventSpanContext(eventSpanContext)
+	b.batch = []CBActionDocument{action}
+	b.bulkRequest()
+
+	if len(handler.errs) != 1 {
+		t.Fatalf("expected the handler to retry the failed action, got %v", handler.errs)
+	}
+	for _, name := range []string{"couchbase.Set", "couchbase.sinkResponseHandler.OnError", "couchbase.retry"} {
+		links := tracer.links[name]
+		if len(links) != 1 || !links[0].SpanContext.Equal(eventSpanContext) {
+			t.Fatalf("expected the %s span to link to the event span, got %v", name, links)
+		}
+	}
+}
+
+func TestProcessor_SpoolingAndRebalancingAreProgress(t *testing.T) {
+	b := newTestProcessor(t, &fakeClient{})
+	stale := func() { b.lastProgressTime.Store(time.Now().Add(-time.Hour).UnixNano()) }
+
+	stale()
+	b.isTargetUnavailable.Store(true)
+	b.batch = []CBActionDocument{NewSetAction([]byte("a"), []byte("a1"))}
+	b.flushMessages()
+	if b.spool.Len() != 1 || b.SinceLastProgress() > time.Minute {
+		t.Fatalf("expected the spooled batch to be progress, spooled %v, since %v", b.spool.Len(), b.SinceLastProgress())
+	}
+
+	stale()
+	b.PrepareStartRebalancing()
+	if b.SinceLastProgress() > time.Minute {
+		t.Fatalf("expected a rebalance to be progress, since %v", b.SinceLastProgress())
+	}
+}
@@ -0,0 +1,119 @@
+package couchbase
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp/logger"
+	jsoniter "github.com/json-iterator/go"
+)
+
+type recordedEvent struct {
+	Time           time.Time `json:"time"`
+	Type           string    `json:"type"`
+	Key            string    `json:"key"`
+	CollectionName string    `json:"collection"`
+	SeqNo          uint64    `json:"seqNo"`
+	VbID           uint16    `json:"vbId"`
+}
+
+type recordedAction struct {
+	Event          *recordedEvent `json:"event,omitempty"`
+	Type           CbAction       `json:"type"`
+	Key            string         `json:"key"`
+	ScopeName      string         `json:"scope"`
+	CollectionName string         `json:"collection"`
+	Path           string         `json:"path,omitempty"`
+	Paths          []string       `json:"paths,omitempty"`
+	ValueSize      int            `json:"valueSize"`
+	Expiry         uint32         `json:"expiry,omitempty"`
+}
+
+// recorderClient replaces the writes of a client with records of the actions, reads still go to the target bucket.
+type recorderClient struct {
+	Client
+	file           *os.File
+	scopeName      string
+	collectionName string
+	mu             sync.Mutex
+}
+
+// NewRecorderClient returns a client for `couchbase.dryRun`, every executed action succeeds without a write and
+// is logged, or appended as a JSON line to `couchbase.dryRun.output` when it is set.
+func NewRecorderClient(client Client, cfg *config.Couchbase) (Client, error) {
+	r := &recorderClient{Client: client, scopeName: cfg.ScopeName, collectionName: cfg.CollectionName}
+
+	if cfg.DryRun.Output != "" {
+		file, err := os.OpenFile(cfg.DryRun.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, err
+		}
+		r.file = file
+	}
+	return r, nil
+}
+
+func (r *recorderClient) Execute(_ context.Context, action *CBActionDocument, callback func(err error)) {
+	line, err := jsoniter.Marshal(r.record(action))
+	if err != nil {
+		callback(err)
+		return
+	}
+
+	if r.file == nil {
+		logger.Log.Info("dry run action: %s", line)
+		callback(nil)
+		return
+	}
+
+	r.mu.Lock()
+	_, err = r.file.Write(append(line, '\n'))
+	r.mu.Unlock()
+	callback(err)
+}
+
+func (r *recorderClient) record(action *CBActionDocument) recordedAction {
+	record := recordedAction{
+		Type:           action.Type,
+		Key:            string(action.ID),
+		ScopeName:      action.ScopeName,
+		CollectionName: action.CollectionName,
+		Path:           string(action.Path),
+		ValueSize:      len(action.Source),
+		Expiry:         action.Expiry,
+	}
+	if record.ScopeName == "" {
+		record.ScopeName = r.scopeName
+	}
+	if record.CollectionName == "" {
+		record.CollectionName = r.collectionName
+	}
+	for _, pathValue := range action.PathValues {
+		record.Paths = append(record.Paths, string(pathValue.Path))
+		record.ValueSize += len(pathValue.Value)
+	}
+
+	if origin := action.origin; origin.eventType != "" {
+		record.Event = &recordedEvent{
+			Time:           origin.time,
+			Type:           origin.eventType,
+			Key:            string(origin.key),
+			CollectionName: origin.collectionName,
+			SeqNo:          origin.seqNo,
+			VbID:           origin.vbID,
+		}
+	}
+	return record
+}
+
+func (r *recorderClient) Close() {
+	if r.file != nil {
+		if err := r.file.Close(); err != nil {
+			logger.Log.Error("error while close dry run output, err: %v", err)
+		}
+	}
+	r.Client.Close()
+}
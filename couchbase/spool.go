@@ -0,0 +1,520 @@
+package couchbase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Trendyol/go-dcp/helpers"
+	"github.com/Trendyol/go-dcp/logger"
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+)
+
+// ErrSpoolFull is returned by Append when the actions do not fit into the free spool size.
+var ErrSpoolFull = errors.New("spool is full")
+
+// ErrSpoolTooSmall is returned by Append when the actions do not fit into the spool even when it is empty.
+var ErrSpoolTooSmall = errors.New("actions exceed the spool size")
+
+// errSpoolRecordChecksum is returned for a record whose length is intact, so the records after it can still be read.
+var errSpoolRecordChecksum = errors.New("record checksum mismatch")
+
+const (
+	spoolSegmentExtension = ".seg"
+	spoolCursorFileName   = "cursor"
+	spoolRecordHeaderSize = 8
+)
+
+type spoolPosition struct {
+	segment uint64
+	offset  int64
+}
+
+// spoolEntry is a pending record, a corrupted one has no action and is skipped by the replay. A corrupted tail
+// covers the unreadable rest of a segment whose record count is unknown.
+type spoolEntry struct {
+	action        CBActionDocument
+	position      spoolPosition
+	next          spoolPosition
+	size          int64
+	corrupted     bool
+	corruptedTail bool
+}
+
+// Spool is a disk-backed write-ahead log of actions which could not be written to the target.
+// Each record is stored as a length and crc32 prefixed json payload in append-only segment files,
+// the read position is kept in a separate cursor file so replay resumes after a restart.
+type Spool struct {
+	writer          *os.File
+	dir             string
+	fsyncPolicy     string
+	segments        []uint64
+	cursor          spoolPosition
+	maxByteSize     int64
+	segmentByteSize int64
+	writerSize      int64
+	byteSize        int64
+	count           int
+	mu              sync.Mutex
+}
+
+func OpenSpool(cfg *config.Spool) (*Spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:             cfg.Dir,
+		fsyncPolicy:     cfg.FsyncPolicy,
+		maxByteSize:     int64(helpers.ResolveUnionIntOrStringValue(cfg.MaxByteSize)),
+		segmentByteSize: int64(helpers.ResolveUnionIntOrStringValue(cfg.SegmentByteSize)),
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("spool opened at %s, pending actions: %v, pending bytes: %v", s.dir, s.count, s.byteSize)
+	return s, nil
+}
+
+// recover loads segments and cursor, drops a torn tail left by a crash and counts the pending records.
+func (s *Spool) recover() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, spoolSegmentExtension) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, id)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	if err := s.readCursor(); err != nil {
+		return err
+	}
+
+	for len(s.segments) > 0 && s.segments[0] < s.cursor.segment {
+		if err := os.Remove(s.segmentPath(s.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.segments = s.segments[1:]
+	}
+
+	if len(s.segments) > 0 {
+		// only the last segment can have a torn tail, the others were synced before the next one was created
+		id := s.segments[len(s.segments)-1]
+		_, _, validSize, err := s.scanSegment(id, s.segmentStart(id))
+		if err != nil {
+			return err
+		}
+		if err := os.Truncate(s.segmentPath(id), validSize); err != nil {
+			return err
+		}
+	}
+
+	return s.recount()
+}
+
+// recount counts the pending records from the cursor, the unreadable rest of a segment is counted by its size only.
+func (s *Spool) recount() error {
+	s.count = 0
+	s.byteSize = 0
+	for _, id := range s.segments {
+		count, byteSize, _, err := s.scanSegment(id, s.segmentStart(id))
+		if err != nil {
+			return err
+		}
+		s.count += count
+		s.byteSize += byteSize
+	}
+	return nil
+}
+
+func (s *Spool) segmentStart(id uint64) int64 {
+	if id == s.cursor.segment {
+		return s.cursor.offset
+	}
+	return 0
+}
+
+// scanSegment returns the count and size of the records from offset from and the end of the last readable record.
+// Records with a checksum mismatch are counted since the replay skips them one by one.
+func (s *Spool) scanSegment(id uint64, from int64) (int, int64, int64, error) {
+	file, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(from, io.SeekStart); err != nil {
+		return 0, 0, 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	offset := from
+	count := 0
+	for {
+		payload, err := readSpoolRecord(reader, s.maxByteSize)
+		if err != nil && !errors.Is(err, errSpoolRecordChecksum) {
+			if !errors.Is(err, io.EOF) {
+				logger.Log.Warn("spool segment %v is unreadable from offset %v, err: %v", id, offset, err)
+			}
+			return count, offset - from, offset, nil
+		}
+		count++
+		offset += spoolRecordHeaderSize + int64(len(payload))
+	}
+}
+
+// readSpoolRecord reads a record of at most maxLength bytes, Append never writes a larger one.
+func readSpoolRecord(reader io.Reader, maxLength int64) ([]byte, error) {
+	var header [spoolRecordHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("torn record header: %w", err)
+		}
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if int64(length) > maxLength {
+		return nil, fmt.Errorf("record length %v exceeds the spool size", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("torn record payload: %w", err)
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return payload, errSpoolRecordChecksum
+	}
+
+	return payload, nil
+}
+
+// Append writes the actions as one unit, it either stores all of them or returns an error.
+func (s *Spool) Append(actions []CBActionDocument) error {
+	records := make([][]byte, 0, len(actions))
+	var needed int64
+	for i := range actions {
+		payload, err := jsoniter.Marshal(&actions[i])
+		if err != nil {
+			return err
+		}
+		records = append(records, payload)
+		needed += spoolRecordHeaderSize + int64(len(payload))
+	}
+
+	if needed > s.maxByteSize {
+		return fmt.Errorf("%w, %v bytes are needed of %v", ErrSpoolTooSmall, needed, s.maxByteSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byteSize+needed > s.maxByteSize {
+		return ErrSpoolFull
+	}
+
+	for _, payload := range records {
+		if err := s.writeRecord(payload); err != nil {
+			return err
+		}
+		if s.fsyncPolicy == config.SpoolFsyncAlways {
+			if err := s.writer.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.fsyncPolicy == config.SpoolFsyncBatch {
+		return s.writer.Sync()
+	}
+
+	return nil
+}
+
+func (s *Spool) writeRecord(payload []byte) error {
+	if s.writer == nil || s.writerSize >= s.segmentByteSize {
+		if err := s.rollSegment(); err != nil {
+			return err
+		}
+	}
+
+	var header [spoolRecordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+
+	if _, err := s.writer.Write(append(header[:], payload...)); err != nil {
+		return err
+	}
+
+	size := spoolRecordHeaderSize + int64(len(payload))
+	s.writerSize += size
+	s.byteSize += size
+	s.count++
+	return nil
+}
+
+func (s *Spool) rollSegment() error {
+	if s.writer != nil {
+		if err := s.writer.Sync(); err != nil {
+			return err
+		}
+		if err := s.writer.Close(); err != nil {
+			return err
+		}
+	}
+
+	id := s.cursor.segment
+	if len(s.segments) > 0 {
+		id = s.segments[len(s.segments)-1] + 1
+	}
+
+	file, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if len(s.segments) == 0 || s.segments[len(s.segments)-1] != id {
+		s.segments = append(s.segments, id)
+	}
+	s.writer = file
+	s.writerSize = info.Size()
+	return nil
+}
+
+// read returns up to limit pending records in append order without consuming them.
+func (s *Spool) read(limit int) ([]spoolEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		return nil, nil
+	}
+
+	if s.writer != nil && s.fsyncPolicy != config.SpoolFsyncNever {
+		if err := s.writer.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]spoolEntry, 0, min(limit, s.count))
+	position := s.cursor
+	for _, id := range s.segments {
+		if id < position.segment {
+			continue
+		}
+		if id > position.segment {
+			position = spoolPosition{segment: id}
+		}
+
+		var err error
+		entries, err = s.readSegment(position, entries, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) >= limit {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *Spool) readSegment(position spoolPosition, entries []spoolEntry, limit int) ([]spoolEntry, error) {
+	file, err := os.Open(s.segmentPath(position.segment))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(position.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	for len(entries) < limit {
+		payload, err := readSpoolRecord(reader, s.maxByteSize)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+
+		entry := spoolEntry{position: position}
+		switch {
+		case errors.Is(err, errSpoolRecordChecksum):
+			entry.corrupted = true
+		case err != nil:
+			// the length of the record is unknown, so the rest of the segment is skipped
+			info, err := file.Stat()
+			if err != nil {
+				return nil, err
+			}
+			entry.corrupted = true
+			entry.corruptedTail = true
+			entry.size = info.Size() - position.offset
+			entry.next = spoolPosition{segment: position.segment, offset: info.Size()}
+			return append(entries, entry), nil
+		default:
+			if err := jsoniter.Unmarshal(payload, &entry.action); err != nil {
+				entry.corrupted = true
+			}
+		}
+
+		entry.size = spoolRecordHeaderSize + int64(len(payload))
+		position.offset += entry.size
+		entry.next = position
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// advance marks the given entries, which must be a prefix of the last read, as consumed.
+func (s *Spool) advance(entries []spoolEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	corruptedTail := false
+	for _, entry := range entries {
+		corruptedTail = corruptedTail || entry.corruptedTail
+		s.byteSize -= entry.size
+	}
+	s.count -= len(entries)
+	s.cursor = entries[len(entries)-1].next
+
+	for len(s.segments) > 1 && s.segments[0] < s.cursor.segment {
+		if err := os.Remove(s.segmentPath(s.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.segments = s.segments[1:]
+	}
+
+	if err := s.writeCursor(); err != nil {
+		return err
+	}
+	if corruptedTail {
+		return s.recount()
+	}
+	return nil
+}
+
+// Len returns the number of actions waiting for replay.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// ByteSize returns the size of the actions waiting for replay.
+func (s *Spool) ByteSize() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.byteSize
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer == nil {
+		return nil
+	}
+
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+	return s.writer.Close()
+}
+
+func (s *Spool) readCursor() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFileName))
+	if os.IsNotExist(err) {
+		if len(s.segments) > 0 {
+			s.cursor = spoolPosition{segment: s.segments[0]}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(data) != 16 {
+		return fmt.Errorf("spool cursor file is corrupted, size: %v", len(data))
+	}
+
+	s.cursor = spoolPosition{
+		segment: binary.BigEndian.Uint64(data[0:8]),
+		offset:  int64(binary.BigEndian.Uint64(data[8:16])),
+	}
+	return nil
+}
+
+// writeCursor replaces the cursor file atomically so a crash never leaves a partially written cursor.
+func (s *Spool) writeCursor() error {
+	var data [16]byte
+	binary.BigEndian.PutUint64(data[0:8], s.cursor.segment)
+	binary.BigEndian.PutUint64(data[8:16], uint64(s.cursor.offset))
+
+	path := filepath.Join(s.dir, spoolCursorFileName)
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data[:]); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if s.fsyncPolicy != config.SpoolFsyncNever {
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolSegmentExtension))
+}
@@ -0,0 +1,310 @@
+package couchbase
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Trendyol/go-dcp/logger"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+)
+
+// discardLogs replaces the package logger until the test ends.
+func discardLogs(t *testing.T) {
+	t.Helper()
+
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	previous := logger.Log
+	logger.Log = &logger.Loggers{Logrus: l}
+	t.Cleanup(func() { logger.Log = previous })
+}
+
+func openTestSpool(t *testing.T, dir string, segmentByteSize int) *Spool {
+	t.Helper()
+
+	discardLogs(t)
+
+	s, err := OpenSpool(&config.Spool{
+		Dir:             dir,
+		MaxByteSize:     1 << 20,
+		SegmentByteSize: segmentByteSize,
+		FsyncPolicy:     config.SpoolFsyncBatch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func appendTestActions(t *testing.T, s *Spool, keys ...string) {
+	t.Helper()
+
+	actions := make([]CBActionDocument, 0, len(keys))
+	for _, key := range keys {
+		actions = append(actions, NewSetAction([]byte(key), []byte(fmt.Sprintf(`{"key":%q}`, key))))
+	}
+	if err := s.Append(actions); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestKeys(t *testing.T, s *Spool, limit int) ([]string, []spoolEntry) {
+	t.Helper()
+
+	entries, err := s.read(limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.corrupted {
+			keys = append(keys, "<corrupted>")
+			continue
+		}
+		keys = append(keys, string(entry.action.ID))
+	}
+	return keys, entries
+}
+
+func assertKeys(t *testing.T, actual []string, expected ...string) {
+	t.Helper()
+
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSpool_Append(t *testing.T) {
+	s := openTestSpool(t, t.TempDir(), 1<<20)
+	appendTestActions(t, s, "a", "b", "c")
+
+	if s.Len() != 3 {
+		t.Fatalf("expected 3 pending actions, got %v", s.Len())
+	}
+
+	keys, entries := readTestKeys(t, s, 10)
+	assertKeys(t, keys, "a", "b", "c")
+	if string(entries[1].action.Source) != `{"key":"b"}` {
+		t.Fatalf("unexpected source %s", entries[1].action.Source)
+	}
+
+	// read does not consume
+	keys, _ = readTestKeys(t, s, 10)
+	assertKeys(t, keys, "a", "b", "c")
+}
+
+func TestSpool_ReplayOrder(t *testing.T) {
+	dir := t.TempDir()
+	// every record rolls a new segment
+	s := openTestSpool(t, dir, 1)
+	appendTestActions(t, s, "a", "b", "c")
+	appendTestActions(t, s, "d", "e")
+
+	if len(segmentFiles(t, dir)) != 5 {
+		t.Fatalf("expected 5 segments, got %v", segmentFiles(t, dir))
+	}
+
+	var replayed []string
+	for s.Len() > 0 {
+		keys, entries := readTestKeys(t, s, 2)
+		replayed = append(replayed, keys...)
+		if err := s.advance(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assertKeys(t, replayed, "a", "b", "c", "d", "e")
+	if len(segmentFiles(t, dir)) != 1 {
+		t.Fatalf("expected consumed segments to be removed, got %v", segmentFiles(t, dir))
+	}
+}
+
+func TestSpool_CursorPersistence(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, 1<<20)
+	appendTestActions(t, s, "a", "b", "c", "d")
+
+	_, entries := readTestKeys(t, s, 2)
+	if err := s.advance(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir, 1<<20)
+	if s.Len() != 2 {
+		t.Fatalf("expected 2 pending actions after reopen, got %v", s.Len())
+	}
+	keys, _ := readTestKeys(t, s, 10)
+	assertKeys(t, keys, "c", "d")
+}
+
+func TestSpool_RecoverTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, 1<<20)
+	appendTestActions(t, s, "a", "b", "c")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := segmentFiles(t, dir)[0]
+	info, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(segment, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir, 1<<20)
+	if s.Len() != 2 {
+		t.Fatalf("expected the torn record to be dropped, got %v pending actions", s.Len())
+	}
+
+	appendTestActions(t, s, "d")
+	keys, _ := readTestKeys(t, s, 10)
+	assertKeys(t, keys, "a", "b", "d")
+}
+
+// recordOffset returns the offset of the record at index idx of a segment.
+func recordOffset(t *testing.T, segment string, idx int) int64 {
+	t.Helper()
+
+	data, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(0)
+	for i := 0; i < idx; i++ {
+		offset += spoolRecordHeaderSize + int64(binary.BigEndian.Uint32(data[offset:offset+4]))
+	}
+	return offset
+}
+
+func corruptSegment(t *testing.T, segment string, offset int64, data []byte) {
+	t.Helper()
+
+	file, err := os.OpenFile(segment, os.O_WRONLY, 0o640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt(data, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpool_SkipCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, 1<<20)
+	appendTestActions(t, s, "a", "b", "c")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segment := segmentFiles(t, dir)[0]
+	corruptSegment(t, segment, recordOffset(t, segment, 1)+spoolRecordHeaderSize, []byte("x"))
+
+	s = openTestSpool(t, dir, 1<<20)
+	if s.Len() != 3 {
+		t.Fatalf("expected the records after the corrupted one to be kept, got %v pending actions", s.Len())
+	}
+
+	keys, entries := readTestKeys(t, s, 10)
+	assertKeys(t, keys, "a", "<corrupted>", "c")
+	if entries[1].position.offset != recordOffset(t, segment, 1) {
+		t.Fatalf("expected the corrupted record offset %v, got %v", recordOffset(t, segment, 1), entries[1].position.offset)
+	}
+
+	if err := s.advance(entries); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || s.ByteSize() != 0 {
+		t.Fatalf("expected the spool to be drained, got %v actions of %v bytes", s.Len(), s.ByteSize())
+	}
+}
+
+func TestSpool_SkipCorruptedLength(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, 1<<20)
+	appendTestActions(t, s, "a", "b", "c")
+
+	segment := segmentFiles(t, dir)[0]
+	corruptSegment(t, segment, recordOffset(t, segment, 1), []byte{0xff, 0xff, 0xff, 0xff})
+
+	keys, entries := readTestKeys(t, s, 10)
+	assertKeys(t, keys, "a", "<corrupted>")
+	if !entries[1].corruptedTail {
+		t.Fatal("expected the rest of the segment to be skipped")
+	}
+
+	if err := s.advance(entries); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || s.ByteSize() != 0 {
+		t.Fatalf("expected the spool to be drained, got %v actions of %v bytes", s.Len(), s.ByteSize())
+	}
+
+	appendTestActions(t, s, "d")
+	keys, _ = readTestKeys(t, s, 10)
+	assertKeys(t, keys, "d")
+}
+
+func TestSpool_AppendTooLarge(t *testing.T) {
+	s := openTestSpool(t, t.TempDir(), 1<<20)
+
+	actions := make([]CBActionDocument, 0, 4)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		actions = append(actions, NewSetAction([]byte(key), []byte(fmt.Sprintf(`{"key":%q}`, key))))
+	}
+	payload, err := jsoniter.Marshal(&actions[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.maxByteSize = 2 * (spoolRecordHeaderSize + int64(len(payload)))
+
+	if err = s.Append(actions); !errors.Is(err, ErrSpoolTooSmall) {
+		t.Fatalf("expected ErrSpoolTooSmall, got %v", err)
+	}
+
+	// the halves are appended one by one while the replay drains the spool
+	replayed := make(chan []string)
+	go func() {
+		var keys []string
+		for len(keys) < len(actions) {
+			entries, err := s.read(len(actions))
+			if err != nil || s.advance(entries) != nil {
+				break
+			}
+			for _, entry := range entries {
+				keys = append(keys, string(entry.action.ID))
+			}
+		}
+		replayed <- keys
+	}()
+
+	b := &Processor{spool: s, actionCounter: newActionCounter("collection"), spoolReplayInterval: time.Millisecond}
+	b.spoolActions(actions)
+	assertKeys(t, <-replayed, "a", "b", "c", "d")
+}
@@ -2,10 +2,13 @@ package couchbase
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/Trendyol/go-dcp-couchbase/config"
 
 	"github.com/couchbase/gocbcore/v10"
+	"github.com/couchbase/gocbcore/v10/memd"
 )
 
 type GetResult struct {
@@ -18,6 +21,22 @@ type TargetClient interface {
 	Get(ctx context.Context, id []byte, cb GetCallback) error
 }
 
+// XattrTargetClient is an optional interface of TargetClient implementations, the target client of the
+// connector implements it.
+type XattrTargetClient interface {
+	// GetXattr reads the extended attribute path of id from scopeName.collectionName, the configured scope and
+	// collection are used when they are empty. The callback gets a nil result when the document or path is missing.
+	GetXattr(ctx context.Context, scopeName string, collectionName string, id []byte, path string, cb GetCallback) error
+}
+
+// PathTargetClient is an optional interface of TargetClient implementations, the target client of the
+// connector implements it.
+type PathTargetClient interface {
+	// GetPath reads the body path of id from scopeName.collectionName, the configured scope and
+	// collection are used when they are empty. The callback gets a nil result when the document or path is missing.
+	GetPath(ctx context.Context, scopeName string, collectionName string, id []byte, path string, cb GetCallback) error
+}
+
 type targetClient struct {
 	agent          *gocbcore.Agent
 	scopeName      string
@@ -50,6 +69,77 @@ func (s *targetClient) Get(ctx context.Context,
 	return err
 }
 
+func (s *targetClient) GetXattr(ctx context.Context,
+	scopeName string,
+	collectionName string,
+	id []byte,
+	path string,
+	cb GetCallback,
+) error {
+	return s.lookupIn(ctx, scopeName, collectionName, id, path, memd.SubdocFlagXattrPath, cb)
+}
+
+func (s *targetClient) GetPath(ctx context.Context,
+	scopeName string,
+	collectionName string,
+	id []byte,
+	path string,
+	cb GetCallback,
+) error {
+	return s.lookupIn(ctx, scopeName, collectionName, id, path, memd.SubdocFlagNone, cb)
+}
+
+func (s *targetClient) lookupIn(ctx context.Context,
+	scopeName string,
+	collectionName string,
+	id []byte,
+	path string,
+	flags memd.SubdocFlag,
+	cb GetCallback,
+) error {
+	deadline, _ := ctx.Deadline()
+
+	if scopeName == "" {
+		scopeName = s.scopeName
+	}
+	if collectionName == "" {
+		collectionName = s.collectionName
+	}
+
+	_, err := s.agent.LookupIn(gocbcore.LookupInOptions{
+		Key: id,
+		Ops: []gocbcore.SubDocOp{
+			{
+				Op:    memd.SubDocOpGet,
+				Flags: flags,
+				Path:  path,
+			},
+		},
+		Deadline:       deadline,
+		ScopeName:      scopeName,
+		CollectionName: collectionName,
+		RetryStrategy:  gocbcore.NewBestEffortRetryStrategy(nil),
+	}, func(result *gocbcore.LookupInResult, err error) {
+		switch {
+		case errors.Is(err, gocbcore.ErrDocumentNotFound):
+			cb(nil, nil)
+		case err != nil:
+			cb(nil, err)
+		case errors.Is(result.Ops[0].Err, gocbcore.ErrPathNotFound):
+			cb(nil, nil)
+		case result.Ops[0].Err != nil:
+			cb(nil, result.Ops[0].Err)
+		default:
+			cb(&GetResult{
+				Value: result.Ops[0].Value,
+				Cas:   uint64(result.Cas),
+			}, nil)
+		}
+	})
+
+	return err
+}
+
 func NewTargetClient(config *config.Config, client Client) TargetClient {
 	return &targetClient{
 		agent:          client.GetAgent(),
@@ -57,3 +147,79 @@ func NewTargetClient(config *config.Config, client Client) TargetClient {
 		collectionName: config.Couchbase.CollectionName,
 	}
 }
+
+// GetDocument is a blocking TargetClient.Get for mappers, it returns nil without an error when id does not exist.
+func GetDocument(ctx context.Context, targetClient TargetClient, id []byte) (*GetResult, error) {
+	return waitGet(ctx, func(cb GetCallback) error {
+		return targetClient.Get(ctx, id, cb)
+	})
+}
+
+// GetXattr is a blocking XattrTargetClient.GetXattr for mappers, it fails when targetClient does not implement it.
+// Xattrs are not cached, a CachedTargetClient reads them from the client it wraps.
+func GetXattr(ctx context.Context,
+	targetClient TargetClient,
+	scopeName string,
+	collectionName string,
+	id []byte,
+	path string,
+) (*GetResult, error) {
+	if cached, ok := targetClient.(*CachedTargetClient); ok {
+		targetClient = cached.TargetClient
+	}
+	xattrTargetClient, ok := targetClient.(XattrTargetClient)
+	if !ok {
+		return nil, fmt.Errorf("target client %T does not read xattrs", targetClient)
+	}
+
+	return waitGet(ctx, func(cb GetCallback) error {
+		return xattrTargetClient.GetXattr(ctx, scopeName, collectionName, id, path, cb)
+	})
+}
+
+// GetPath is a blocking PathTargetClient.GetPath for mappers, it fails when targetClient does not implement it.
+// Paths are not cached, a CachedTargetClient reads them from the client it wraps.
+func GetPath(ctx context.Context,
+	targetClient TargetClient,
+	scopeName string,
+	collectionName string,
+	id []byte,
+	path string,
+) (*GetResult, error) {
+	if cached, ok := targetClient.(*CachedTargetClient); ok {
+		targetClient = cached.TargetClient
+	}
+	pathTargetClient, ok := targetClient.(PathTargetClient)
+	if !ok {
+		return nil, fmt.Errorf("target client %T does not read paths", targetClient)
+	}
+
+	return waitGet(ctx, func(cb GetCallback) error {
+		return pathTargetClient.GetPath(ctx, scopeName, collectionName, id, path, cb)
+	})
+}
+
+func waitGet(ctx context.Context, get func(cb GetCallback) error) (*GetResult, error) {
+	type getResponse struct {
+		result *GetResult
+		err    error
+	}
+
+	ch := make(chan getResponse, 1)
+	err := get(func(result *GetResult, err error) {
+		ch <- getResponse{result: result, err: err}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-ch:
+		if errors.Is(response.err, gocbcore.ErrDocumentNotFound) {
+			return nil, nil
+		}
+		return response.result, response.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package couchbase
+
+import (
+	"context"
+	"errors"
+
+	"github.com/couchbase/gocbcore/v10"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Trendyol/go-dcp-couchbase"
+
+const (
+	attributeActionType  = attribute.Key("couchbase.action.type")
+	attributeKey         = attribute.Key("couchbase.document.key")
+	attributeScope       = attribute.Key("couchbase.scope")
+	attributeCollection  = attribute.Key("couchbase.collection")
+	attributeStatusCode  = attribute.Key("couchbase.status_code")
+	attributeVbID        = attribute.Key("couchbase.vbucket")
+	attributeSeqNo       = attribute.Key("couchbase.seq_no")
+	attributeActionCount = attribute.Key("couchbase.action.count")
+	attributeByteSize    = attribute.Key("couchbase.batch.byte_size")
+	attributeSpooled     = attribute.Key("couchbase.spooled")
+)
+
+func (b *Processor) startActionSpan(ctx context.Context, name string, action *CBActionDocument) (context.Context, trace.Span) {
+	scopeName, collectionName := action.ScopeName, action.CollectionName
+	if scopeName == "" {
+		scopeName = b.scopeName
+	}
+	if collectionName == "" {
+		collectionName = b.collectionName
+	}
+
+	options := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attributeActionType.String(string(action.Type)),
+			attributeKey.String(string(action.ID)),
+			attributeScope.String(scopeName),
+			attributeCollection.String(collectionName),
+		),
+	}
+	if action.origin.seqNo > 0 {
+		// the same attributes are on the couchbase.event span of the go-dcp listener trace
+		options = append(options, trace.WithAttributes(
+			attributeVbID.Int(int(action.origin.vbID)),
+			attributeSeqNo.Int64(int64(action.origin.seqNo)),
+		))
+	}
+	if action.eventSpanContext.IsValid() {
+		options = append(options, trace.WithLinks(trace.Link{SpanContext: action.eventSpanContext}))
+	}
+	return b.tracer.Start(ctx, name, options...)
+}
+
+// endSpan marks the span failed by err, key value errors also set the status code attribute.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		var kvErr *gocbcore.KeyValueError
+		if errors.As(err, &kvErr) {
+			span.SetAttributes(attributeStatusCode.String(kvErr.StatusCode.String()))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
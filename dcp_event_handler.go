@@ -5,8 +5,10 @@ import (
 )
 
 type DcpEventHandler struct {
-	processor *couchbase.Processor
-	isFinite  bool
+	processor     *couchbase.Processor
+	mapperBatcher *mapperBatcher
+	mapperStates  []resettable
+	isFinite      bool
 }
 
 func (d *DcpEventHandler) BeforeRebalanceStart() {
@@ -32,6 +34,12 @@ func (d *DcpEventHandler) BeforeStreamStop() {
 	if d.isFinite {
 		return
 	}
+	if d.mapperBatcher != nil {
+		d.mapperBatcher.reset()
+	}
+	for _, state := range d.mapperStates {
+		state.reset()
+	}
 	d.processor.PrepareStartRebalancing()
 }
 
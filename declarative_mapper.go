@@ -0,0 +1,314 @@
+package dcpcouchbase
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type declarativeRule struct {
+	collections map[string]struct{}
+	events      map[string]struct{}
+	keyTemplate *keyTemplate
+	where       []declarativeCondition
+	include     [][]string
+	exclude     [][]string
+	rename      []declarativeRename
+	constants   []declarativeConstant
+	needsValue  bool
+}
+
+type declarativeCondition struct {
+	exists    *bool
+	equals    []byte
+	notEquals []byte
+	in        [][]byte
+	path      []string
+}
+
+type declarativeRename struct {
+	from []string
+	to   []string
+}
+
+type declarativeConstant struct {
+	value any
+	path  []string
+}
+
+type declarativeMapper struct {
+	rules []*declarativeRule
+}
+
+// NewDeclarativeMapper compiles `couchbase.mapper` rules. The first rule matching the event's collection,
+// type and where conditions is applied, events without a matching rule are skipped.
+// Mutations are written as a Set of the projected value, deletions and expirations as a Delete.
+func NewDeclarativeMapper(cfg config.DeclarativeMapper) (MapperE, error) {
+	m, err := newDeclarativeMapper(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		declarativeMapperTrace := ctx.CreateChildTrace("DeclarativeMapper", map[string]interface{}{})
+		defer declarativeMapperTrace.Finish()
+
+		return m.mapEvent(&ctx.Event)
+	}, nil
+}
+
+func newDeclarativeMapper(cfg config.DeclarativeMapper) (*declarativeMapper, error) {
+	m := &declarativeMapper{rules: make([]*declarativeRule, 0, len(cfg.Rules))}
+	for idx, ruleConfig := range cfg.Rules {
+		rule, err := compileDeclarativeRule(ruleConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couchbase.mapper.rules[%v]: %w", idx, err)
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+// mapEvent decodes the value only once a selected rule needs it, so values of events no rule selects,
+// or of rules which copy the value as is, may be binary.
+func (m *declarativeMapper) mapEvent(e *couchbase.Event) ([]couchbase.CBActionDocument, error) {
+	var doc map[string]any
+	for _, rule := range m.rules {
+		if !rule.selects(e) {
+			continue
+		}
+
+		if e.IsMutated && rule.needsValue && doc == nil {
+			var err error
+			if doc, err = decodeJSONObject(e.Value); err != nil {
+				return nil, err
+			}
+		}
+
+		if !e.IsMutated || rule.matchesWhere(doc) {
+			return rule.apply(e, doc)
+		}
+	}
+	return nil, nil
+}
+
+func compileDeclarativeRule(cfg config.MapperRule) (*declarativeRule, error) {
+	rule := &declarativeRule{
+		collections: toSet(cfg.Collections),
+		events:      toSet(cfg.Events),
+	}
+
+	for event := range rule.events {
+		if event != couchbase.MutationEventType && event != couchbase.DeletionEventType && event != couchbase.ExpirationEventType {
+			return nil, fmt.Errorf("unknown event type %q", event)
+		}
+	}
+
+	if cfg.KeyTemplate != "" {
+		template, err := compileKeyTemplate(cfg.KeyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if template.usesValues && rule.acceptsEventWithoutValue() {
+			return nil, fmt.Errorf("key template %q uses value placeholders, rule must be limited to mutation events", cfg.KeyTemplate)
+		}
+		rule.keyTemplate = template
+	}
+
+	for _, condition := range cfg.Where {
+		compiled, err := compileDeclarativeCondition(condition)
+		if err != nil {
+			return nil, err
+		}
+		rule.where = append(rule.where, compiled)
+	}
+
+	for _, path := range cfg.Include {
+		rule.include = append(rule.include, splitJSONPath(path))
+	}
+	for _, path := range cfg.Exclude {
+		rule.exclude = append(rule.exclude, splitJSONPath(path))
+	}
+
+	for _, from := range sortedKeys(cfg.Rename) {
+		rule.rename = append(rule.rename, declarativeRename{from: splitJSONPath(from), to: splitJSONPath(cfg.Rename[from])})
+	}
+	for _, path := range sortedKeys(cfg.Constants) {
+		rule.constants = append(rule.constants, declarativeConstant{path: splitJSONPath(path), value: cfg.Constants[path]})
+	}
+
+	rule.needsValue = len(rule.where) > 0 || len(rule.include) > 0 || len(rule.exclude) > 0 || len(rule.rename) > 0 ||
+		len(rule.constants) > 0 || (rule.keyTemplate != nil && rule.keyTemplate.usesValues)
+
+	return rule, nil
+}
+
+func compileDeclarativeCondition(cfg config.MapperCondition) (declarativeCondition, error) {
+	if cfg.Path == "" {
+		return declarativeCondition{}, fmt.Errorf("where condition requires a path")
+	}
+
+	condition := declarativeCondition{path: splitJSONPath(cfg.Path), exists: cfg.Exists}
+
+	var err error
+	if cfg.Equals != nil {
+		if condition.equals, err = json.Marshal(cfg.Equals); err != nil {
+			return condition, err
+		}
+	}
+	if cfg.NotEquals != nil {
+		if condition.notEquals, err = json.Marshal(cfg.NotEquals); err != nil {
+			return condition, err
+		}
+	}
+	for _, value := range cfg.In {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return condition, err
+		}
+		condition.in = append(condition.in, encoded)
+	}
+
+	return condition, nil
+}
+
+func (r *declarativeRule) acceptsEventWithoutValue() bool {
+	if len(r.events) == 0 {
+		return true
+	}
+	_, deletion := r.events[couchbase.DeletionEventType]
+	_, expiration := r.events[couchbase.ExpirationEventType]
+	return deletion || expiration
+}
+
+// selects checks the collection and type of the event. Where conditions are not checked for deletions
+// and expirations since they carry no value, deleting a document which was never written is harmless.
+func (r *declarativeRule) selects(e *couchbase.Event) bool {
+	if len(r.collections) > 0 {
+		if _, ok := r.collections[e.CollectionName]; !ok {
+			return false
+		}
+	}
+
+	if len(r.events) > 0 {
+		if _, ok := r.events[e.Type()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *declarativeRule) matchesWhere(doc map[string]any) bool {
+	for i := range r.where {
+		if !r.where[i].matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *declarativeCondition) matches(doc map[string]any) bool {
+	value, exists := getJSONPath(doc, c.path)
+	if c.exists != nil && *c.exists != exists {
+		return false
+	}
+
+	if c.equals == nil && c.notEquals == nil && c.in == nil {
+		return true
+	}
+
+	if !exists {
+		return c.equals == nil && c.in == nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+
+	if c.equals != nil && !bytes.Equal(encoded, c.equals) {
+		return false
+	}
+	if c.notEquals != nil && bytes.Equal(encoded, c.notEquals) {
+		return false
+	}
+	if c.in != nil {
+		for _, candidate := range c.in {
+			if bytes.Equal(encoded, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (r *declarativeRule) apply(e *couchbase.Event, doc map[string]any) ([]couchbase.CBActionDocument, error) {
+	key := e.Key
+	if r.keyTemplate != nil {
+		var err error
+		if key, err = r.keyTemplate.execute(e, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if !e.IsMutated {
+		return []couchbase.CBActionDocument{couchbase.NewDeleteAction(key)}, nil
+	}
+	if !r.needsValue {
+		return []couchbase.CBActionDocument{couchbase.NewSetAction(key, e.Value)}, nil
+	}
+
+	if len(r.include) > 0 {
+		projected := map[string]any{}
+		for _, path := range r.include {
+			if value, ok := getJSONPath(doc, path); ok {
+				setJSONPath(projected, path, value)
+			}
+		}
+		doc = projected
+	}
+
+	for _, path := range r.exclude {
+		deleteJSONPath(doc, path)
+	}
+
+	for _, rename := range r.rename {
+		if value, ok := getJSONPath(doc, rename.from); ok {
+			deleteJSONPath(doc, rename.from)
+			setJSONPath(doc, rename.to, value)
+		}
+	}
+
+	for _, constant := range r.constants {
+		setJSONPath(doc, constant.path, constant.value)
+	}
+
+	value, err := jsonNumber.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return []couchbase.CBActionDocument{couchbase.NewSetAction(key, value)}, nil
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,391 @@
+package dcpcouchbase
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type eventFixture struct {
+	EventTime      time.Time       `json:"eventTime"`
+	Key            string          `json:"key"`
+	CollectionName string          `json:"collectionName"`
+	Type           string          `json:"type"`
+	RawValue       string          `json:"rawValue"`
+	Value          json.RawMessage `json:"value"`
+	Cas            uint64          `json:"cas"`
+	SeqNo          uint64          `json:"seqNo"`
+	RevNo          uint64          `json:"revNo"`
+	VbID           uint16          `json:"vbId"`
+}
+
+func loadEventFixtures(t *testing.T, path string) map[string]couchbase.Event {
+	t.Helper()
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fixtures map[string]eventFixture
+	if err := json.Unmarshal(file, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(map[string]couchbase.Event, len(fixtures))
+	for name, f := range fixtures {
+		value := []byte(f.Value)
+		if f.RawValue != "" {
+			value = []byte(f.RawValue)
+		}
+
+		switch f.Type {
+		case couchbase.MutationEventType:
+			events[name] = couchbase.NewMutateEvent(
+				[]byte(f.Key), value,
+				f.CollectionName, f.EventTime, f.Cas, f.VbID, f.SeqNo, f.RevNo,
+			).WithOptions(couchbase.EventOptions{ScopeName: config.DefaultScopeName, Datatype: couchbase.DatatypeJSON})
+		case couchbase.DeletionEventType:
+			events[name] = couchbase.NewDeleteEvent(
+				[]byte(f.Key), nil,
+				f.CollectionName, f.EventTime, f.Cas, f.VbID, f.SeqNo, f.RevNo,
+			).WithOptions(couchbase.EventOptions{ScopeName: config.DefaultScopeName})
+		case couchbase.ExpirationEventType:
+			events[name] = couchbase.NewExpireEvent(
+				[]byte(f.Key), nil,
+				f.CollectionName, f.EventTime, f.Cas, f.VbID, f.SeqNo, f.RevNo,
+			).WithOptions(couchbase.EventOptions{ScopeName: config.DefaultScopeName})
+		default:
+			t.Fatalf("fixture %s has unknown type %s", name, f.Type)
+		}
+	}
+	return events
+}
+
+type expectedAction struct {
+	Type   couchbase.CbAction
+	ID     string
+	Source string
+}
+
+type declarativeMapperTest struct {
+	name    string
+	mapper  string
+	event   string
+	want    []expectedAction
+	wantErr bool
+}
+
+var declarativeMapperTests = []declarativeMapperTest{
+	{
+		name: "projection keeps only included paths",
+		mapper: `
+rules:
+  - include: [id, name, price.amount]
+`,
+		event: "product-mutation",
+		want: []expectedAction{
+			{Type: couchbase.Set, ID: "product:1", Source: `{"id":1,"name":"Mug","price":{"amount":12990}}`},
+		},
+	},
+	{
+		name: "exclude, rename and constants are applied in order",
+		mapper: `
+rules:
+  - exclude: [internal, seller.name]
+    rename:
+      price.amount: priceAmount
+      name: title
+    constants:
+      source: dcp
+      meta.version: 2
+`,
+		event: "product-mutation",
+		want: []expectedAction{
+			{
+				Type: couchbase.Set,
+				ID:   "product:1",
+				Source: `{"id":1,"title":"Mug","status":"active","price":{"currency":"TRY"},"priceAmount":12990,
+"seller":{"id":42},"source":"dcp","meta":{"version":2}}`,
+			},
+		},
+	},
+	{
+		name: "key template uses event metadata and value fields",
+		mapper: `
+rules:
+  - events: [mutation]
+    keyTemplate: "{collection}::{value.seller.id}::{key}::{eventTime:2006-01-02}::{vbId}-{seqNo}-{revNo}"
+    include: [id]
+`,
+		event: "product-mutation",
+		want: []expectedAction{
+			{Type: couchbase.Set, ID: "products::42::product:1::2024-03-05::12-345-7", Source: `{"id":1}`},
+		},
+	},
+	{
+		name: "where condition filters by field value",
+		mapper: `
+rules:
+  - where:
+      - path: status
+        equals: active
+`,
+		event: "passive-product-mutation",
+		want:  nil,
+	},
+	{
+		name: "where condition with in and exists",
+		mapper: `
+rules:
+  - where:
+      - path: status
+        in: [active, passive]
+      - path: seller.id
+        exists: true
+      - path: deletedAt
+        exists: false
+    include: [id]
+`,
+		event: "passive-product-mutation",
+		want:  []expectedAction{{Type: couchbase.Set, ID: "product:2", Source: `{"id":2}`}},
+	},
+	{
+		name: "numeric where condition compares json values",
+		mapper: `
+rules:
+  - where:
+      - path: seller.id
+        notEquals: 42
+  - keyTemplate: "seller-42::{key}"
+    include: [id]
+`,
+		event: "product-mutation",
+		want:  []expectedAction{{Type: couchbase.Set, ID: "seller-42::product:1", Source: `{"id":1}`}},
+	},
+	{
+		name: "first matching rule by collection wins",
+		mapper: `
+rules:
+  - collections: [orders]
+    keyTemplate: "order::{key}"
+  - collections: [products]
+    keyTemplate: "product::{key}"
+`,
+		event: "order-mutation",
+		want: []expectedAction{
+			{Type: couchbase.Set, ID: "order::order:9", Source: `{"orderId":9,"sellerId":42,"total":100}`},
+		},
+	},
+	{
+		name: "deletion ignores where conditions and applies the key template",
+		mapper: `
+rules:
+  - where:
+      - path: status
+        equals: active
+    keyTemplate: "mirror::{key}"
+`,
+		event: "product-deletion",
+		want:  []expectedAction{{Type: couchbase.Delete, ID: "mirror::product:1"}},
+	},
+	{
+		name: "expiration is skipped when the rule only accepts deletions",
+		mapper: `
+rules:
+  - events: [deletion]
+`,
+		event: "product-expiration",
+		want:  nil,
+	},
+	{
+		name: "non json value is reported as error",
+		mapper: `
+rules:
+  - include: [id]
+`,
+		event:   "binary-mutation",
+		wantErr: true,
+	},
+	{
+		name: "non json value of a collection no rule selects is skipped",
+		mapper: `
+rules:
+  - collections: [orders]
+    include: [id]
+`,
+		event: "binary-mutation",
+		want:  nil,
+	},
+	{
+		name: "non json value is copied by a rule without value access",
+		mapper: `
+rules:
+  - keyTemplate: "mirror::{key}"
+`,
+		event: "binary-mutation",
+		want:  []expectedAction{{Type: couchbase.Set, ID: "mirror::blob:1"}},
+	},
+	{
+		name: "missing value placeholder is reported as error",
+		mapper: `
+rules:
+  - events: [mutation]
+    keyTemplate: "{value.category.id}"
+`,
+		event:   "product-mutation",
+		wantErr: true,
+	},
+}
+
+func TestDeclarativeMapper(t *testing.T) {
+	events := loadEventFixtures(t, "testdata/declarative_mapper/events.json")
+
+	for _, tt := range declarativeMapperTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.DeclarativeMapper
+			if err := yaml.Unmarshal([]byte(tt.mapper), &cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := newDeclarativeMapper(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event, ok := events[tt.event]
+			if !ok {
+				t.Fatalf("fixture %s not found", tt.event)
+			}
+
+			actions, err := m.mapEvent(&event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mapEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(actions) != len(tt.want) {
+				t.Fatalf("mapEvent() returned %v actions, want %v", len(actions), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				got := actions[i]
+				if got.Type != want.Type || string(got.ID) != want.ID {
+					t.Errorf("action[%v] = %s %s, want %s %s", i, got.Type, got.ID, want.Type, want.ID)
+				}
+				if want.Source != "" && !jsonEqual(t, got.Source, []byte(want.Source)) {
+					t.Errorf("action[%v] source = %s, want %s", i, got.Source, want.Source)
+				}
+			}
+		})
+	}
+}
+
+func TestDeclarativeMapperCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		mapper string
+	}{
+		{
+			name: "unknown event type",
+			mapper: `
+rules:
+  - events: [update]
+`,
+		},
+		{
+			name: "unknown key template placeholder",
+			mapper: `
+rules:
+  - keyTemplate: "{bucket}::{key}"
+`,
+		},
+		{
+			name: "value placeholder on a rule accepting deletions",
+			mapper: `
+rules:
+  - keyTemplate: "{value.id}"
+`,
+		},
+		{
+			name: "where condition without path",
+			mapper: `
+rules:
+  - where:
+      - equals: 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg config.DeclarativeMapper
+			if err := yaml.Unmarshal([]byte(tt.mapper), &cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := NewDeclarativeMapper(cfg); err == nil {
+				t.Error("NewDeclarativeMapper() expected error")
+			}
+		})
+	}
+}
+
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := &config.Config{Couchbase: config.Couchbase{Hosts: []string{"localhost:8091"}, BucketName: "bucket"}}
+	cfg.ApplyDefaults()
+	cfg.Couchbase.Mapper.Rules = []config.MapperRule{
+		{KeyTemplate: "order::{value.id}", Events: []string{couchbase.MutationEventType}},
+		{KeyTemplate: "order::{value.id}"},
+		{KeyTemplate: "order::{unknown}"},
+	}
+	cfg.Couchbase.Aggregation.Views = []config.AggregationView{
+		{Name: "totals", GroupBy: "{value.customer", Aggregates: []config.Aggregate{{Field: "count", Function: config.AggregateCount}}},
+	}
+	cfg.Couchbase.Expression.Filter = `type == `
+	cfg.Couchbase.Expression.Routes = []config.ExpressionRoute{{When: `startsWith(key, "a")`}, {When: `unknown == 1`}}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected Validate not to check the syntax, got %v", err)
+	}
+	err := ValidateConfig(cfg)
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	fields := []string{
+		"couchbase.mapper.rules[1].keyTemplate",
+		"couchbase.mapper.rules[2].keyTemplate",
+		"couchbase.aggregation.views[0].groupBy",
+		"couchbase.expression.filter",
+		"couchbase.expression.routes[1].when",
+	}
+	if len(lines) != len(fields) {
+		t.Fatalf("expected %v errors, got %v", len(fields), err)
+	}
+	for idx, field := range fields {
+		if !strings.HasPrefix(lines[idx], field+": ") {
+			t.Errorf("expected error of %v, got %v", field, lines[idx])
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+// relativeExpiryLimit is the longest expiry the server accepts as seconds from now, longer ones are unix times.
+const relativeExpiryLimit = 30 * 24 * time.Hour
+
+type deletionMark struct {
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+type deletionPolicy struct {
+	// pending tracks the keys whose mutations are not written yet, it keeps no written entries
+	pending *writtenValues[struct{}]
+	flush   func() error
+	cfg     config.Deletion
+	timeout time.Duration
+}
+
+// DeletionPolicyMiddleware applies `couchbase.defaultMapper.deletion` to deletions and expirations,
+// mutations and deletions of collections with the hardDelete mode are passed to next.
+//
+//   - markDeleted sets `{"at": <event time>, "reason": "deleted" | "expired"}` to markPath of an existing target document
+//   - archive copies the target document to the archive collection with archiveTTL, then deletes it
+//   - ignore keeps the target document
+//
+// archive fails for a document whose mutation is still waiting in the batch, since the target holds an older version.
+func DeletionPolicyMiddleware(cfg config.Deletion, timeout time.Duration) (Middleware, error) {
+	p, err := newDeletionPolicy(cfg, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.middleware, nil
+}
+
+// newDeletionPolicy calls flush to write the batch before archiving a document with pending writes.
+func newDeletionPolicy(cfg config.Deletion, timeout time.Duration, flush func() error) (*deletionPolicy, error) {
+	if err := validateDeletionPolicy(cfg.DeletionPolicy); err != nil {
+		return nil, fmt.Errorf("couchbase.defaultMapper.deletion: %w", err)
+	}
+	for collectionName, policy := range cfg.Collections {
+		if err := validateDeletionPolicy(policy); err != nil {
+			return nil, fmt.Errorf("couchbase.defaultMapper.deletion.collections.%v: %w", collectionName, err)
+		}
+	}
+
+	return &deletionPolicy{
+		pending: newWrittenValues[struct{}](0),
+		flush:   flush,
+		cfg:     cfg,
+		timeout: timeout,
+	}, nil
+}
+
+func (p *deletionPolicy) middleware(next MapperE) MapperE {
+	return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		policy, ok := p.cfg.Collections[ctx.CollectionName]
+		if !ok {
+			policy = p.cfg.DeletionPolicy
+		}
+
+		if ctx.IsMutated {
+			actions, err := next(ctx)
+			if err == nil && policy.Mode == config.DeletionModeArchive {
+				p.pending.track(string(ctx.Key), struct{}{}, actions)
+			}
+			return actions, err
+		}
+
+		switch policy.Mode {
+		case config.DeletionModeMarkDeleted:
+			return markDeleted(ctx, policy)
+		case config.DeletionModeArchive:
+			return p.archive(ctx, policy)
+		case config.DeletionModeIgnore:
+			return nil, nil
+		default:
+			return next(ctx)
+		}
+	}
+}
+
+func (p *deletionPolicy) reset() {
+	p.pending.reset()
+}
+
+func validateDeletionPolicy(policy config.DeletionPolicy) error {
+	switch policy.Mode {
+	case config.DeletionModeHardDelete, config.DeletionModeMarkDeleted, config.DeletionModeIgnore:
+		return nil
+	case config.DeletionModeArchive:
+		if policy.ArchiveCollectionName == "" {
+			return fmt.Errorf("archive mode needs archiveCollectionName")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected mode %q", policy.Mode)
+	}
+}
+
+func markDeleted(ctx couchbase.EventContext, policy config.DeletionPolicy) ([]couchbase.CBActionDocument, error) {
+	reason := "deleted"
+	if ctx.IsExpired {
+		reason = "expired"
+	}
+
+	action, err := couchbase.NewTypedMutateInAction(ctx.Key, []byte(policy.MarkPath), deletionMark{
+		At:     ctx.EventTime.UTC(),
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// a deletion of a document which never reached the target does not create a document holding only the mark
+	action.SetDisableAutoCreate(true)
+	return []couchbase.CBActionDocument{action}, nil
+}
+
+// archive reads the target without the cache. A pending write of the document is flushed first,
+// the archive fails when it can not be written, e.g. while the processor is paused.
+func (p *deletionPolicy) archive(ctx couchbase.EventContext, policy config.DeletionPolicy) ([]couchbase.CBActionDocument, error) {
+	if p.isPending(ctx.Key) && p.flush != nil {
+		if err := p.flush(); err != nil {
+			return nil, fmt.Errorf("archive of %s: %w", ctx.Key, err)
+		}
+	}
+	if p.isPending(ctx.Key) {
+		return nil, fmt.Errorf("archive of %s: a write of the document is still pending", ctx.Key)
+	}
+
+	getCtx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	target, err := couchbase.GetDocument(getCtx, uncached(ctx.TargetClient), ctx.Key)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, nil
+	}
+
+	archived := couchbase.NewSetAction(ctx.Key, target.Value)
+	archived.SetScopeName(policy.ArchiveScopeName)
+	archived.SetCollectionName(policy.ArchiveCollectionName)
+	archived.SetExpiry(toExpiry(policy.ArchiveTTL, time.Now()))
+
+	return []couchbase.CBActionDocument{archived, couchbase.NewDeleteAction(ctx.Key)}, nil
+}
+
+func (p *deletionPolicy) isPending(key []byte) bool {
+	_, ok := p.pending.get(string(key))
+	return ok
+}
+
+// toExpiry converts ttl to the server expiry format, 0 never expires.
+func toExpiry(ttl time.Duration, now time.Time) uint32 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl < relativeExpiryLimit:
+		return uint32(ttl.Seconds())
+	default:
+		return uint32(now.Add(ttl).Unix())
+	}
+}
@@ -0,0 +1,103 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type valueTargetClient struct {
+	value []byte
+}
+
+func (c *valueTargetClient) Get(_ context.Context, _ []byte, cb couchbase.GetCallback) error {
+	cb(&couchbase.GetResult{Value: c.value}, nil)
+	return nil
+}
+
+func newDeletionContext(targetClient couchbase.TargetClient) couchbase.EventContext {
+	return couchbase.EventContext{
+		TargetClient:  targetClient,
+		ListenerTrace: noopListenerTrace{},
+		Event:         couchbase.NewDeleteEvent([]byte("k"), nil, "orders", time.Time{}, 1, 2, 3, 4),
+	}
+}
+
+func newTestDeletionPolicy(t *testing.T, mode string, flush func() error) MapperE {
+	t.Helper()
+
+	cfg := config.Deletion{DeletionPolicy: config.DeletionPolicy{
+		Mode: mode, MarkPath: "deleted", ArchiveCollectionName: "archive",
+	}}
+	p, err := newDeletionPolicy(cfg, time.Second, flush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.middleware(setMapper)
+}
+
+func TestDeletionPolicy_MarkDeletedDoesNotCreateDocuments(t *testing.T) {
+	mapper := newTestDeletionPolicy(t, config.DeletionModeMarkDeleted, nil)
+
+	actions, err := mapper(newDeletionContext(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Type != couchbase.MutateIn || string(actions[0].Path) != "deleted" ||
+		!actions[0].DisableAutoCreate {
+		t.Fatalf("expected a MutateIn of the mark which does not create the document, got %+v", actions)
+	}
+}
+
+func TestDeletionPolicy_ArchiveReadsWithoutCache(t *testing.T) {
+	mapper := newTestDeletionPolicy(t, config.DeletionModeArchive, nil)
+	target := &valueTargetClient{value: []byte(`{"v":1}`)}
+	cached := couchbase.NewCachedTargetClient(target, &config.TargetClientCache{Size: 1, TTL: time.Hour})
+	if _, err := couchbase.GetDocument(context.Background(), cached, []byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	target.value = []byte(`{"v":2}`)
+
+	actions, err := mapper(newDeletionContext(cached))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 || string(actions[0].Source) != `{"v":2}` || actions[0].CollectionName != "archive" ||
+		actions[1].Type != couchbase.Delete {
+		t.Fatalf("expected the current version to be archived, got %+v", actions)
+	}
+}
+
+func TestDeletionPolicy_ArchiveWithPendingWrite(t *testing.T) {
+	flushErr := errors.New("processor is paused")
+
+	tests := []struct {
+		flush   func() error
+		name    string
+		wantErr string
+	}{
+		{name: "without flush", wantErr: "archive of k: a write of the document is still pending"},
+		{name: "flush fails", flush: func() error { return flushErr }, wantErr: "archive of k: processor is paused"},
+		{name: "write is still pending after the flush", flush: func() error { return nil },
+			wantErr: "archive of k: a write of the document is still pending"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := newTestDeletionPolicy(t, config.DeletionModeArchive, tt.flush)
+			if _, err := mapper(newMiddlewareContext("k")); err != nil {
+				t.Fatal(err)
+			}
+
+			actions, err := mapper(newDeletionContext(&valueTargetClient{value: []byte(`{}`)}))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) || actions != nil {
+				t.Fatalf("expected error %q, got %v %v", tt.wantErr, actions, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type diffMapper struct {
+	written  *writtenValues[[]byte]
+	basePath string
+	timeout  time.Duration
+}
+
+// NewDiffMapper reads the current target document for every mutation and only writes the fields which changed,
+// so fields owned by other writers of the target document are kept. basePath limits the diff to a subtree
+// of the target document, e.g. `source` when the target document is `{"source": <source document>, ...}`.
+// Fields dropped from the source are only removed below basePath, see couchbase.NewDiffActions.
+// Deletions delete the target document, or only basePath when it is set.
+//
+// The values written for the last config.DefaultDiffCacheSize documents are kept, so the next mutation of a
+// document compares against them even while its previous write is still in the batch. Other lookups bypass
+// `couchbase.targetClientCache`, whose entries may predate the last write.
+func NewDiffMapper(basePath string, timeout time.Duration) MapperE {
+	return newDiffMapper(basePath, config.DefaultDiffCacheSize, timeout).mapEvent
+}
+
+func newDiffMapper(basePath string, cacheSize int, timeout time.Duration) *diffMapper {
+	return &diffMapper{
+		written:  newWrittenValues[[]byte](cacheSize),
+		basePath: basePath,
+		timeout:  timeout,
+	}
+}
+
+func (m *diffMapper) mapEvent(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+	diffMapperTrace := ctx.CreateChildTrace("DiffMapper", map[string]interface{}{})
+	defer diffMapperTrace.Finish()
+
+	key := string(ctx.Key)
+	if !ctx.IsMutated {
+		action := couchbase.NewDeletePathAction(ctx.Key, []byte(m.basePath))
+		if m.basePath == "" {
+			action = couchbase.NewDeleteAction(ctx.Key)
+		}
+		actions := []couchbase.CBActionDocument{action}
+		m.written.track(key, nil, actions)
+		return actions, nil
+	}
+
+	previous, ok := m.written.get(key)
+	if !ok {
+		var err error
+		if previous, err = m.read(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	actions, err := couchbase.NewDiffActions(ctx.Key, m.basePath, previous, ctx.Value)
+	if err != nil {
+		return nil, err
+	}
+	m.written.track(key, append([]byte(nil), ctx.Value...), actions)
+	return actions, nil
+}
+
+// read returns the subtree at basePath of the target document, or nil when it does not exist.
+func (m *diffMapper) read(ctx couchbase.EventContext) ([]byte, error) {
+	getCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	target, err := couchbase.GetDocument(getCtx, uncached(ctx.TargetClient), ctx.Key)
+	if err != nil || target == nil {
+		return nil, err
+	}
+	return diffBase(target.Value, m.basePath)
+}
+
+// uncached bypasses `couchbase.targetClientCache`, whose entries may predate the last write.
+func uncached(targetClient couchbase.TargetClient) couchbase.TargetClient {
+	if cached, ok := targetClient.(*couchbase.CachedTargetClient); ok {
+		return cached.TargetClient
+	}
+	return targetClient
+}
+
+func (m *diffMapper) reset() {
+	m.written.reset()
+}
+
+// diffBase returns the subtree at basePath of the target document, or nil when it does not exist.
+func diffBase(value []byte, basePath string) ([]byte, error) {
+	if basePath == "" {
+		return value, nil
+	}
+
+	doc, err := decodeJSONObject(value)
+	if err != nil {
+		return nil, err
+	}
+
+	subtree, ok := getJSONPath(doc, splitJSONPath(basePath))
+	if !ok {
+		return nil, nil
+	}
+	return jsonNumber.Marshal(subtree)
+}
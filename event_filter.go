@@ -0,0 +1,180 @@
+package dcpcouchbase
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	"github.com/Trendyol/go-dcp/models"
+)
+
+const (
+	collectionFilterName = "collections"
+	keyPrefixFilterName  = "keyPrefixes"
+	keyPatternFilterName = "keyPatterns"
+	eventTypeFilterName  = "eventTypes"
+)
+
+type keyFilter struct {
+	match   func(key []byte) bool
+	dropped atomic.Int64
+	name    string
+	include bool
+}
+
+type setFilter struct {
+	values  map[string]struct{}
+	dropped atomic.Int64
+	name    string
+	include bool
+}
+
+// eventFilter drops events by the raw DCP fields, so skipped events are not converted to an Event.
+type eventFilter struct {
+	keyFilters        []*keyFilter
+	collectionFilters []*setFilter
+	eventTypeFilters  []*setFilter
+}
+
+func newEventFilter(cfg config.EventFilter) (*eventFilter, error) {
+	f := &eventFilter{}
+
+	f.collectionFilters = newSetFilters(collectionFilterName, cfg.Collections)
+
+	for _, eventTypes := range [][]string{cfg.EventTypes.Include, cfg.EventTypes.Exclude} {
+		for _, eventType := range eventTypes {
+			switch eventType {
+			case couchbase.MutationEventType, couchbase.DeletionEventType, couchbase.ExpirationEventType:
+			default:
+				return nil, fmt.Errorf("couchbase.eventFilter.eventTypes: unknown event type %q", eventType)
+			}
+		}
+	}
+	f.eventTypeFilters = newSetFilters(eventTypeFilterName, cfg.EventTypes)
+
+	prefixes := func(values []string) func(key []byte) bool {
+		return func(key []byte) bool {
+			for _, prefix := range values {
+				if bytes.HasPrefix(key, []byte(prefix)) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	f.addKeyFilter(keyPrefixFilterName, true, cfg.KeyPrefixes.Include, prefixes(cfg.KeyPrefixes.Include))
+	f.addKeyFilter(keyPrefixFilterName, false, cfg.KeyPrefixes.Exclude, prefixes(cfg.KeyPrefixes.Exclude))
+
+	for _, list := range []struct {
+		values  []string
+		include bool
+	}{{cfg.KeyPatterns.Include, true}, {cfg.KeyPatterns.Exclude, false}} {
+		patterns := make([]*regexp.Regexp, 0, len(list.values))
+		for _, value := range list.values {
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("couchbase.eventFilter.keyPatterns: %w", err)
+			}
+			patterns = append(patterns, pattern)
+		}
+		f.addKeyFilter(keyPatternFilterName, list.include, list.values, func(key []byte) bool {
+			for _, pattern := range patterns {
+				if pattern.Match(key) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if len(f.keyFilters) == 0 && len(f.collectionFilters) == 0 && len(f.eventTypeFilters) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func newSetFilters(name string, list config.FilterList) []*setFilter {
+	var filters []*setFilter
+	if len(list.Include) > 0 {
+		filters = append(filters, &setFilter{name: filterName(name, true), include: true, values: toSet(list.Include)})
+	}
+	if len(list.Exclude) > 0 {
+		filters = append(filters, &setFilter{name: filterName(name, false), values: toSet(list.Exclude)})
+	}
+	return filters
+}
+
+func (f *eventFilter) addKeyFilter(name string, include bool, values []string, match func(key []byte) bool) {
+	if len(values) > 0 {
+		f.keyFilters = append(f.keyFilters, &keyFilter{name: filterName(name, include), include: include, match: match})
+	}
+}
+
+// filterName is the metric label of a filter, e.g. `keyPrefixes.include`.
+func filterName(name string, include bool) string {
+	if include {
+		return name + ".include"
+	}
+	return name + ".exclude"
+}
+
+// drop reports whether the event fails one of the filters, every configured include list has to match
+// and no exclude list may match.
+func (f *eventFilter) drop(ctx *models.ListenerContext) bool {
+	var key []byte
+	var collectionName, eventType string
+	switch event := ctx.Event.(type) {
+	case models.DcpMutation:
+		key, collectionName, eventType = event.Key, event.CollectionName, couchbase.MutationEventType
+	case models.DcpDeletion:
+		key, collectionName, eventType = event.Key, event.CollectionName, couchbase.DeletionEventType
+	case models.DcpExpiration:
+		key, collectionName, eventType = event.Key, event.CollectionName, couchbase.ExpirationEventType
+	default:
+		return false
+	}
+
+	for _, filter := range f.eventTypeFilters {
+		if filter.drop(eventType) {
+			return true
+		}
+	}
+	for _, filter := range f.collectionFilters {
+		if filter.drop(collectionName) {
+			return true
+		}
+	}
+	for _, filter := range f.keyFilters {
+		if filter.match(key) != filter.include {
+			filter.dropped.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *setFilter) drop(value string) bool {
+	if _, ok := f.values[value]; ok != f.include {
+		f.dropped.Add(1)
+		return true
+	}
+	return false
+}
+
+// droppedCounts returns the dropped event count by filter name.
+func (f *eventFilter) droppedCounts() map[string]int64 {
+	counts := map[string]int64{}
+	for _, filter := range f.eventTypeFilters {
+		counts[filter.name] = filter.dropped.Load()
+	}
+	for _, filter := range f.collectionFilters {
+		counts[filter.name] = filter.dropped.Load()
+	}
+	for _, filter := range f.keyFilters {
+		counts[filter.name] = filter.dropped.Load()
+	}
+	return counts
+}
@@ -0,0 +1,78 @@
+// Package expression implements a small expression language evaluated against couchbase.Event.
+//
+// Identifiers are key, scope, collection, type, cas, vbId, seqNo, revNo, flags, expiry
+// and `value.<dotted json path>`.
+// Literals are strings in single or double quotes, numbers, true, false and null.
+// Integers are compared exactly, so cas and seqNo can be matched above 2^53.
+// Operators are ==, !=, <, <=, >, >=, &&, || and !, functions are
+// startsWith, endsWith, contains, matches, lower and exists.
+//
+//	type == "mutation" && startsWith(key, "product:") && value.status != "passive"
+package expression
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type Expression struct {
+	root node
+	src  string
+}
+
+type environment struct {
+	event     *couchbase.Event
+	doc       map[string]any
+	docLoaded bool
+}
+
+// Compile parses src and resolves identifiers and function arguments, so a typo fails at startup
+// instead of silently filtering every event.
+func Compile(src string) (*Expression, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %q at %v", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+
+	return &Expression{root: root, src: src}, nil
+}
+
+// Evaluate returns a string, number, bool, json object or array, or nil. Integers are uint64,
+// or int64 when negative, other numbers are float64.
+func (e *Expression) Evaluate(event *couchbase.Event) any {
+	return e.root.eval(&environment{event: event})
+}
+
+// Match reports whether the expression evaluates to true.
+func (e *Expression) Match(event *couchbase.Event) bool {
+	result, ok := e.Evaluate(event).(bool)
+	return ok && result
+}
+
+func (e *Expression) String() string {
+	return e.src
+}
+
+func (env *environment) value() map[string]any {
+	if !env.docLoaded {
+		env.docLoaded = true
+		if len(env.event.Value) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(env.event.Value))
+			decoder.UseNumber()
+			_ = decoder.Decode(&env.doc)
+		}
+	}
+	return env.doc
+}
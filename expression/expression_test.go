@@ -0,0 +1,159 @@
+package expression
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+const testEventValue = `{"status":"active","price":10,"tags":["a","b"],"owner":{"name":"x"},"other":{"name":"x"},` +
+	`"big":9007199254740993}`
+
+func testEvent() *couchbase.Event {
+	return &couchbase.Event{
+		Key:            []byte("product:1"),
+		Value:          []byte(testEventValue),
+		ScopeName:      "_default",
+		CollectionName: "products",
+		IsMutated:      true,
+		Cas:            9007199254740993,
+		SeqNo:          42,
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src   string
+		err   string
+		kinds []tokenKind
+	}{
+		{src: "", kinds: []tokenKind{tokenEOF}},
+		{src: "  key  ", kinds: []tokenKind{tokenIdentifier, tokenEOF}},
+		{src: `f("a", -1.5)`, kinds: []tokenKind{
+			tokenIdentifier, tokenLeftParen, tokenString, tokenComma, tokenNumber, tokenRightParen, tokenEOF,
+		}},
+		{src: "a<=b&&!c", kinds: []tokenKind{
+			tokenIdentifier, tokenOperator, tokenIdentifier, tokenOperator, tokenOperator, tokenIdentifier, tokenEOF,
+		}},
+		{src: `'it\'s'`, kinds: []tokenKind{tokenString, tokenEOF}},
+		{src: `"open`, err: "unterminated string at 0"},
+		{src: "1.2.3", err: `invalid number "1.2.3" at 0`},
+		{src: "18446744073709551616", err: `invalid number "18446744073709551616" at 0`},
+		{src: "a # b", err: `unexpected character '#' at 2`},
+		{src: "a = b", err: `unexpected character '=' at 2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			tokens, err := tokenize(tt.src)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tokens) != len(tt.kinds) {
+				t.Fatalf("expected %v tokens, got %v", len(tt.kinds), tokens)
+			}
+			for i, kind := range tt.kinds {
+				if tokens[i].kind != kind {
+					t.Fatalf("token %v: expected kind %v, got %v", i, kind, tokens[i].kind)
+				}
+			}
+		})
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{src: "", err: "unexpected end of expression"},
+		{src: "key ==", err: "unexpected end of expression"},
+		{src: "(key", err: "expected"},
+		{src: "key key", err: `unexpected "key" at 4`},
+		{src: "unknown == 1", err: `unknown identifier "unknown" at 0`},
+		{src: "nope(key)", err: `unknown function "nope" at 0`},
+		{src: "lower(key, key)", err: `function "lower" expects 1 arguments, got 2`},
+		{src: "matches(key, scope)", err: "expects a string literal pattern"},
+		{src: `matches(key, "(")`, err: "missing closing )"},
+		{src: "exists(key)", err: "exists"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Compile(tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestExpression_Evaluate(t *testing.T) {
+	tests := []struct {
+		expected any
+		src      string
+	}{
+		// precedence: ! binds tighter than comparisons, && tighter than ||
+		{src: "true || false && false", expected: true},
+		{src: "(true || false) && false", expected: false},
+		{src: "!false && false", expected: false},
+		{src: "!(seqNo > 1)", expected: false},
+		{src: `type == "mutation" && startsWith(key, "product:") || false`, expected: true},
+		// numbers and strings
+		{src: "seqNo >= 42 && seqNo < 43", expected: true},
+		{src: "value.price > 9.5", expected: true},
+		{src: `value.status <= "active"`, expected: true},
+		// integers above 2^53 compare exactly, also with floats
+		{src: "cas == 9007199254740993", expected: true},
+		{src: "cas == 9007199254740992", expected: false},
+		{src: "cas > 9007199254740992 && cas != 9007199254740992.0", expected: true},
+		{src: "value.big == cas && value.big >= 9007199254740993", expected: true},
+		{src: "value.price == 10.0 && -1 < seqNo && seqNo > -1.5", expected: true},
+		{src: `lower("ABC") == "abc"`, expected: true},
+		{src: `contains(collection, "prod") && endsWith(key, ":1")`, expected: true},
+		{src: `matches(key, "^product:[0-9]+$")`, expected: true},
+		{src: "exists(value.owner.name) && !exists(value.missing)", expected: true},
+		// mixed types never compare as ordered, and are never equal
+		{src: `seqNo < "50"`, expected: false},
+		{src: `value.price == "10"`, expected: false},
+		{src: "value.status != 1", expected: true},
+		{src: "value.missing == null", expected: true},
+		{src: "value.tags > 1", expected: false},
+		// objects and arrays compare deeply instead of panicking
+		{src: "value.owner == value.other", expected: true},
+		{src: "value.tags == value.tags", expected: true},
+		{src: "value.tags != value.owner", expected: true},
+		// non-string function arguments
+		{src: "lower(seqNo)", expected: nil},
+		{src: "startsWith(key, seqNo)", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := e.Evaluate(testEvent()); actual != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExpression_Match(t *testing.T) {
+	e, err := Compile("seqNo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Match(testEvent()) {
+		t.Fatal("expected a non bool result not to match")
+	}
+}
@@ -0,0 +1,139 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+)
+
+type token struct {
+	number any
+	text   string
+	kind   tokenKind
+	pos    int
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(src); {
+		c := rune(src[pos])
+		if unicode.IsSpace(c) {
+			pos++
+			continue
+		}
+
+		tok, next, err := scanToken(src, pos)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		pos = next
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// scanToken scans the token starting at pos and returns it with the position after it.
+func scanToken(src string, pos int) (token, int, error) {
+	c := rune(src[pos])
+	switch {
+	case c == '(':
+		return token{kind: tokenLeftParen, text: "(", pos: pos}, pos + 1, nil
+	case c == ')':
+		return token{kind: tokenRightParen, text: ")", pos: pos}, pos + 1, nil
+	case c == ',':
+		return token{kind: tokenComma, text: ",", pos: pos}, pos + 1, nil
+	case c == '"' || c == '\'':
+		text, next, err := scanString(src, pos)
+		if err != nil {
+			return token{}, 0, err
+		}
+		return token{kind: tokenString, text: text, pos: pos}, next, nil
+	case unicode.IsDigit(c) || (c == '-' && pos+1 < len(src) && unicode.IsDigit(rune(src[pos+1]))):
+		return scanNumber(src, pos)
+	case isIdentifierStart(c):
+		end := pos + 1
+		for end < len(src) && isIdentifierPart(rune(src[end])) {
+			end++
+		}
+		return token{kind: tokenIdentifier, text: src[pos:end], pos: pos}, end, nil
+	default:
+		operator := matchOperator(src[pos:])
+		if operator == "" {
+			return token{}, 0, fmt.Errorf("unexpected character %q at %v", c, pos)
+		}
+		return token{kind: tokenOperator, text: operator, pos: pos}, pos + len(operator), nil
+	}
+}
+
+func scanNumber(src string, start int) (token, int, error) {
+	end := start + 1
+	for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.') {
+		end++
+	}
+	number, err := parseNumber(src[start:end])
+	if err != nil {
+		return token{}, 0, fmt.Errorf("invalid number %q at %v", src[start:end], start)
+	}
+	return token{kind: tokenNumber, text: src[start:end], number: number, pos: start}, end, nil
+}
+
+// parseNumber keeps integers as uint64, or int64 when negative, so they compare exactly with cas and seqNo.
+func parseNumber(text string) (any, error) {
+	if strings.ContainsAny(text, ".eE") {
+		return strconv.ParseFloat(text, 64)
+	}
+	if strings.HasPrefix(text, "-") {
+		return strconv.ParseInt(text, 10, 64)
+	}
+	return strconv.ParseUint(text, 10, 64)
+}
+
+func scanString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var sb strings.Builder
+	for pos := start + 1; pos < len(src); pos++ {
+		switch src[pos] {
+		case quote:
+			return sb.String(), pos + 1, nil
+		case '\\':
+			if pos+1 < len(src) {
+				pos++
+			}
+		}
+		sb.WriteByte(src[pos])
+	}
+	return "", 0, fmt.Errorf("unterminated string at %v", start)
+}
+
+func matchOperator(src string) string {
+	for _, operator := range operators {
+		if strings.HasPrefix(src, operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+func isIdentifierStart(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
+}
+
+func isIdentifierPart(c rune) bool {
+	return isIdentifierStart(c) || unicode.IsDigit(c) || c == '.'
+}
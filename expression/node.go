@@ -0,0 +1,256 @@
+package expression
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var eventFields = map[string]struct{}{
+	"key":        {},
+	"scope":      {},
+	"collection": {},
+	"type":       {},
+	"cas":        {},
+	"vbId":       {},
+	"seqNo":      {},
+	"revNo":      {},
+	"flags":      {},
+	"expiry":     {},
+}
+
+var functionArity = map[string]int{
+	"startsWith": 2,
+	"endsWith":   2,
+	"contains":   2,
+	"matches":    2,
+	"lower":      1,
+	"exists":     1,
+}
+
+type node interface {
+	eval(env *environment) any
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(*environment) any {
+	return n.value
+}
+
+type fieldNode struct {
+	name string
+}
+
+func (n *fieldNode) eval(env *environment) any {
+	e := env.event
+	switch n.name {
+	case "key":
+		return string(e.Key)
+	case "scope":
+		return e.ScopeName
+	case "collection":
+		return e.CollectionName
+	case "type":
+		return e.Type()
+	case "cas":
+		return e.Cas
+	case "vbId":
+		return uint64(e.VbID)
+	case "seqNo":
+		return e.SeqNo
+	case "revNo":
+		return e.RevNo
+	case "flags":
+		return uint64(e.Flags)
+	case "expiry":
+		return uint64(e.Expiry)
+	}
+	return nil
+}
+
+type valueNode struct {
+	path []string
+}
+
+func (n *valueNode) lookup(env *environment) (any, bool) {
+	var current any = env.value()
+	for _, name := range n.path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func (n *valueNode) eval(env *environment) any {
+	value, _ := n.lookup(env)
+	if number, ok := value.(json.Number); ok {
+		return toNumber(number)
+	}
+	return value
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(env *environment) any {
+	return !isTrue(n.operand.eval(env))
+}
+
+type andNode struct {
+	left  node
+	right node
+}
+
+func (n *andNode) eval(env *environment) any {
+	return isTrue(n.left.eval(env)) && isTrue(n.right.eval(env))
+}
+
+type orNode struct {
+	left  node
+	right node
+}
+
+func (n *orNode) eval(env *environment) any {
+	return isTrue(n.left.eval(env)) || isTrue(n.right.eval(env))
+}
+
+type compareNode struct {
+	left     node
+	right    node
+	operator string
+}
+
+// eval compares numbers and strings by value, other types only support equality.
+// Integers are compared exactly, also with floats. Objects and arrays are compared deeply, so they never panic on ==.
+func (n *compareNode) eval(env *environment) any {
+	left, right := n.left.eval(env), n.right.eval(env)
+
+	cmp, isNumeric := compareNumbers(left, right)
+	switch n.operator {
+	case "==":
+		if isNumeric {
+			return cmp == 0
+		}
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		if isNumeric {
+			return cmp != 0
+		}
+		return !reflect.DeepEqual(left, right)
+	}
+
+	switch l := left.(type) {
+	case uint64, int64, float64:
+		if !isNumeric {
+			return false
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+
+	switch n.operator {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+type callNode struct {
+	re       *regexp.Regexp
+	function string
+	args     []node
+}
+
+func (n *callNode) eval(env *environment) any {
+	if n.function == "exists" {
+		_, ok := n.args[0].(*valueNode).lookup(env)
+		return ok
+	}
+
+	first, ok := n.args[0].eval(env).(string)
+	if !ok {
+		return nil
+	}
+
+	switch n.function {
+	case "lower":
+		return strings.ToLower(first)
+	case "matches":
+		return n.re.MatchString(first)
+	}
+
+	second, ok := n.args[1].eval(env).(string)
+	if !ok {
+		return false
+	}
+
+	switch n.function {
+	case "startsWith":
+		return strings.HasPrefix(first, second)
+	case "endsWith":
+		return strings.HasSuffix(first, second)
+	default:
+		return strings.Contains(first, second)
+	}
+}
+
+// compareNumbers compares uint64, int64 and float64 values without converting integers to float64.
+func compareNumbers(left, right any) (int, bool) {
+	l, ok := toBigFloat(left)
+	if !ok {
+		return 0, false
+	}
+	r, ok := toBigFloat(right)
+	if !ok {
+		return 0, false
+	}
+	return l.Cmp(r), true
+}
+
+func toBigFloat(value any) (*big.Float, bool) {
+	switch v := value.(type) {
+	case uint64:
+		return new(big.Float).SetUint64(v), true
+	case int64:
+		return new(big.Float).SetInt64(v), true
+	case float64:
+		return big.NewFloat(v), true
+	}
+	return nil, false
+}
+
+// toNumber converts a json number like parseNumber, the number is kept as a string when it does not fit.
+func toNumber(number json.Number) any {
+	value, err := parseNumber(number.String())
+	if err != nil {
+		return number.String()
+	}
+	return value
+}
+
+func isTrue(value any) bool {
+	b, ok := value.(bool)
+	return ok && b
+}
@@ -0,0 +1,209 @@
+package expression
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const valuePrefix = "value."
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) acceptOperator(operators ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if t.text == operator {
+			p.next()
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind {
+		return fmt.Errorf("expected %q at %v, got %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseExpression() (node, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("||"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOperator("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.acceptOperator("!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	operator, ok := p.acceptOperator("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{operator: operator, left: left, right: right}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenNumber:
+		return &literalNode{value: t.number}, nil
+	case tokenLeftParen:
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(tokenRightParen, ")")
+	case tokenIdentifier:
+		if p.peek().kind == tokenLeftParen {
+			return p.parseCall(t)
+		}
+		return p.resolveIdentifier(t)
+	default:
+		if t.kind == tokenEOF {
+			return nil, fmt.Errorf("unexpected end of expression")
+		}
+		return nil, fmt.Errorf("unexpected %q at %v", t.text, t.pos)
+	}
+}
+
+func (p *parser) resolveIdentifier(t token) (node, error) {
+	switch t.text {
+	case "true":
+		return &literalNode{value: true}, nil
+	case "false":
+		return &literalNode{value: false}, nil
+	case "null":
+		return &literalNode{value: nil}, nil
+	}
+
+	if strings.HasPrefix(t.text, valuePrefix) {
+		return &valueNode{path: strings.Split(t.text[len(valuePrefix):], ".")}, nil
+	}
+
+	if _, ok := eventFields[t.text]; !ok {
+		return nil, fmt.Errorf("unknown identifier %q at %v", t.text, t.pos)
+	}
+	return &fieldNode{name: t.text}, nil
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	arity, ok := functionArity[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %v", name.text, name.pos)
+	}
+
+	p.next()
+	var args []node
+	for p.peek().kind != tokenRightParen {
+		if len(args) > 0 {
+			if err := p.expect(tokenComma, ","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if len(args) != arity {
+		return nil, fmt.Errorf("function %q expects %v arguments, got %v", name.text, arity, len(args))
+	}
+
+	call := &callNode{function: name.text, args: args}
+	switch name.text {
+	case "matches":
+		pattern, ok := args[1].(*literalNode)
+		if !ok {
+			return nil, fmt.Errorf("function \"matches\" expects a string literal pattern")
+		}
+		source, ok := pattern.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("function \"matches\" expects a string literal pattern")
+		}
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return nil, err
+		}
+		call.re = re
+	case "exists":
+		if _, ok := args[0].(*valueNode); !ok {
+			return nil, fmt.Errorf("function \"exists\" expects a value field")
+		}
+	}
+	return call, nil
+}
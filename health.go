@@ -0,0 +1,150 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	"github.com/Trendyol/go-dcp/logger"
+)
+
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type Health struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+func newHealth(checks ...HealthCheck) Health {
+	h := Health{Status: HealthStatusUp, Checks: checks}
+	for _, check := range checks {
+		if check.Status != HealthStatusUp {
+			h.Status = HealthStatusDown
+		}
+	}
+	return h
+}
+
+func healthCheck(name string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, Status: HealthStatusDown, Detail: err.Error()}
+	}
+	return HealthCheck{Name: name, Status: HealthStatusUp}
+}
+
+// Liveness fails when no batch was written or spooled for `couchbase.api.livenessFlushTimeout`, a restart is the
+// only way out of a stuck processor. A paused or rebalancing processor is live, so is one spooling for an unavailable
+// target, the spool may wait for the replay to free space.
+func (c *connector) Liveness() Health {
+	var err error
+	if c.processor.IsPaused() || c.processor.IsRebalancing() || c.processor.IsTargetUnavailable() {
+		return newHealth(healthCheck("flush", nil))
+	}
+	if since := c.processor.SinceLastProgress(); since > c.config.Couchbase.API.LivenessFlushTimeout {
+		err = fmt.Errorf("no batch written or spooled for %v", since.Truncate(time.Second))
+	}
+	return newHealth(healthCheck("flush", err))
+}
+
+// Readiness fails while the connector can not write to the target bucket, it pings the target nodes.
+func (c *connector) Readiness(ctx context.Context) Health {
+	var started, target, processor, rebalance error
+
+	if !c.isStarted.Load() {
+		started = errors.New("waiting for dcp")
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, c.config.Couchbase.API.PingTimeout)
+	defer cancel()
+	target = couchbase.Ping(pingCtx, c.client)
+
+	switch {
+	case c.processor.IsPaused():
+		processor = errors.New("paused")
+	case c.processor.IsTargetUnavailable():
+		processor = errors.New("target is unavailable, actions are spooled")
+	}
+	if c.processor.IsRebalancing() {
+		rebalance = errors.New("dcp is rebalancing")
+	}
+
+	return newHealth(
+		healthCheck("dcp", started),
+		healthCheck("target", target),
+		healthCheck("processor", processor),
+		healthCheck("rebalance", rebalance),
+	)
+}
+
+// Handler serves `/health/live`, `/health/ready` and the `/admin` endpoints. With `couchbase.api.enabled` it is
+// served on `couchbase.api.port`, it can also be mounted on any other server.
+func (c *connector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	c.handleAPI(mux)
+	return mux
+}
+
+func (c *connector) handleAPI(mux *http.ServeMux) {
+	c.handleAdmin(mux)
+	mux.HandleFunc("GET /health/live", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, c.Liveness())
+	})
+	mux.HandleFunc("GET /health/ready", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, c.Readiness(r.Context()))
+	})
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	status := http.StatusOK
+	if h.Status != HealthStatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Error("error while write api response, err: %v", err)
+	}
+}
+
+// startAPI serves the connector endpoints on `couchbase.api.port`, the go-dcp API keeps its own port.
+func (c *connector) startAPI() {
+	c.apiServer = &http.Server{
+		Addr:              ":" + strconv.Itoa(c.config.Couchbase.API.Port),
+		Handler:           c.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := c.apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log.Error("error while serve api, err: %v", err)
+			panic(err)
+		}
+	}()
+}
+
+func (c *connector) closeAPI() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.apiServer.Shutdown(ctx); err != nil {
+		logger.Log.Error("error while close api, err: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const historyKeySeparator = "::"
+
+// maxPrunedVersions keeps the pruning in the pointer mutation next to the new entry and latest.
+const maxPrunedVersions = 14
+
+type historyVersion struct {
+	At    time.Time `json:"at"`
+	Type  string    `json:"type"`
+	Cas   uint64    `json:"cas"`
+	SeqNo uint64    `json:"seqNo"`
+	RevNo uint64    `json:"revNo"`
+}
+
+type historyMapper struct {
+	collections map[string]struct{}
+	// versions are the versions listed by the recently written pointer documents
+	versions *writtenValues[[]uint64]
+	cfg      config.History
+	timeout  time.Duration
+}
+
+// NewHistoryMapper writes every mutation as an immutable version document `<key>::<revNo or seqNo>` with the
+// source body and flags to the history collection. The pointer document `<key>` in the same collection lists
+// the versions under `versions.<version>` and the newest one under `latest`, deletions and expirations are only
+// listed there.
+//
+// Retention by count keeps the newest count versions listed by the pointer document, older ones are removed from
+// it in the same mutation as the new entry, and their version documents are deleted. The versions of the last
+// cfg.CacheSize written pointer documents are kept in memory, the others are read from the pointer document,
+// so the targetClient must implement couchbase.PathTargetClient. Retention by ttl expires the version documents,
+// and the pointer document ttl after its last update.
+func NewHistoryMapper(cfg config.History, timeout time.Duration) (MapperE, error) {
+	m, err := newHistoryMapper(cfg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return m.mapContext, nil
+}
+
+func newHistoryMapper(cfg config.History, timeout time.Duration) (*historyMapper, error) {
+	if cfg.CollectionName == "" {
+		return nil, errors.New("couchbase.history.collectionName is empty")
+	}
+
+	switch cfg.VersionKey {
+	case config.HistoryVersionKeyRevNo:
+	case config.HistoryVersionKeySeqNo:
+		if cfg.Retention.Count > 0 {
+			return nil, errors.New("couchbase.history.retention.count needs the revNo versionKey")
+		}
+	default:
+		return nil, fmt.Errorf("couchbase.history.versionKey: unexpected value %q", cfg.VersionKey)
+	}
+
+	return &historyMapper{
+		collections: toSet(cfg.Collections),
+		versions:    newWrittenValues[[]uint64](cfg.CacheSize),
+		cfg:         cfg,
+		timeout:     timeout,
+	}, nil
+}
+
+func (m *historyMapper) mapContext(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+	historyMapperTrace := ctx.CreateChildTrace("HistoryMapper", map[string]interface{}{})
+	defer historyMapperTrace.Finish()
+
+	return m.mapEvent(ctx.TargetClient, &ctx.Event)
+}
+
+func (m *historyMapper) mapEvent(targetClient couchbase.TargetClient, e *couchbase.Event) ([]couchbase.CBActionDocument, error) {
+	if len(m.collections) > 0 {
+		if _, ok := m.collections[e.CollectionName]; !ok {
+			return nil, nil
+		}
+	}
+
+	version := e.RevNo
+	if m.cfg.VersionKey == config.HistoryVersionKeySeqNo {
+		version = e.SeqNo
+	}
+	expiry := toExpiry(m.cfg.Retention.TTL, time.Now())
+
+	var actions []couchbase.CBActionDocument
+	if e.IsMutated {
+		versionDocument := couchbase.NewSetAction(historyKey(e.Key, version), e.Value)
+		versionDocument.SetDocumentFlags(e.Flags)
+		versionDocument.SetExpiry(expiry)
+		actions = append(actions, versionDocument)
+	}
+
+	entry, err := couchbase.NewTypedPathValue(versionPath(version), historyVersion{
+		At:    e.EventTime.UTC(),
+		Type:  e.Type(),
+		Cas:   e.Cas,
+		SeqNo: e.SeqNo,
+		RevNo: e.RevNo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry.CreateParents = true
+
+	latest, err := couchbase.NewTypedPathValue([]byte("latest"), version)
+	if err != nil {
+		return nil, err
+	}
+
+	pathValues := []couchbase.PathValue{entry, latest}
+	var kept, pruned []uint64
+	if m.cfg.Retention.Count > 0 {
+		if kept, pruned, err = m.prune(targetClient, e.Key, version); err != nil {
+			return nil, err
+		}
+	}
+	for _, expired := range pruned {
+		pathValues = append(pathValues, couchbase.PathValue{Op: couchbase.PathRemove, Path: versionPath(expired)})
+		actions = append(actions, couchbase.NewDeleteAction(historyKey(e.Key, expired)))
+	}
+
+	pointer := couchbase.NewMultiMutateInAction(e.Key, pathValues)
+	pointer.SetExpiry(expiry)
+	actions = append(actions, pointer)
+
+	for i := range actions {
+		actions[i].SetScopeName(m.cfg.ScopeName)
+		actions[i].SetCollectionName(m.cfg.CollectionName)
+	}
+	if m.cfg.Retention.Count > 0 {
+		m.versions.track(string(e.Key), kept, actions[len(actions)-1:])
+	}
+	return actions, nil
+}
+
+// prune returns the versions the pointer document keeps with version, and the oldest ones beyond the retention
+// count which are removed, at most maxPrunedVersions per event.
+func (m *historyMapper) prune(targetClient couchbase.TargetClient, key []byte, version uint64) ([]uint64, []uint64, error) {
+	versions, err := m.pointerVersions(targetClient, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(versions, version) {
+		versions = append(slices.Clone(versions), version)
+		slices.Sort(versions)
+	}
+
+	pruneCount := min(max(len(versions)-m.cfg.Retention.Count, 0), maxPrunedVersions)
+	return versions[pruneCount:], versions[:pruneCount], nil
+}
+
+// pointerVersions prefers the versions of the last write, since it may still be in the batch.
+func (m *historyMapper) pointerVersions(targetClient couchbase.TargetClient, key []byte) ([]uint64, error) {
+	if versions, ok := m.versions.get(string(key)); ok {
+		return versions, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	result, err := couchbase.GetPath(ctx, targetClient, m.cfg.ScopeName, m.cfg.CollectionName, key, "versions")
+	if err != nil || result == nil {
+		return nil, err
+	}
+
+	var entries map[string]jsoniter.RawMessage
+	if err := jsonNumber.Unmarshal(result.Value, &entries); err != nil {
+		return nil, fmt.Errorf("versions of history pointer %s: %w", key, err)
+	}
+	versions := make([]uint64, 0, len(entries))
+	for entry := range entries {
+		version, err := strconv.ParseUint(entry, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("versions of history pointer %s: %w", key, err)
+		}
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
+
+func (m *historyMapper) reset() {
+	m.versions.reset()
+}
+
+func historyKey(key []byte, version uint64) []byte {
+	return []byte(string(key) + historyKeySeparator + strconv.FormatUint(version, 10))
+}
+
+func versionPath(version uint64) []byte {
+	return []byte("versions." + strconv.FormatUint(version, 10))
+}
@@ -0,0 +1,127 @@
+package dcpcouchbase
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type pathTargetClient struct {
+	valueTargetClient
+	paths map[string]string
+	reads int
+}
+
+func (c *pathTargetClient) GetPath(_ context.Context, _ string, _ string, id []byte, path string, cb couchbase.GetCallback) error {
+	c.reads++
+	value, ok := c.paths[string(id)+"."+path]
+	if !ok {
+		cb(nil, nil)
+		return nil
+	}
+	cb(&couchbase.GetResult{Value: []byte(value)}, nil)
+	return nil
+}
+
+var testHistory = config.History{
+	CollectionName: "history",
+	VersionKey:     config.HistoryVersionKeyRevNo,
+	Retention:      config.HistoryRetention{Count: 2},
+	CacheSize:      10,
+}
+
+func newHistoryEvent(key string, revNo uint64) couchbase.Event {
+	return couchbase.NewMutateEvent([]byte(key), []byte(`{}`), "orders", time.Time{}, 1, 2, 3, revNo)
+}
+
+// formatPrunedVersions formats the keys of the deleted version documents and the removed pointer paths.
+func formatPrunedVersions(actions []couchbase.CBActionDocument) []string {
+	var pruned []string
+	for _, action := range actions {
+		if action.Type == couchbase.Delete {
+			pruned = append(pruned, string(action.ID))
+		}
+		for _, pv := range action.PathValues {
+			if pv.Op == couchbase.PathRemove {
+				pruned = append(pruned, string(pv.Path))
+			}
+		}
+	}
+	return pruned
+}
+
+func TestHistoryMapper_PrunesTheVersionsOfThePointer(t *testing.T) {
+	m, err := newHistoryMapper(testHistory, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// revNos 2, 3 and 5 to 8 were deduplicated by dcp
+	target := &pathTargetClient{paths: map[string]string{"o1.versions": `{"1":{},"4":{},"9":{}}`}}
+
+	e := newHistoryEvent("o1", 12)
+	actions, err := m.mapEvent(target, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"o1::1", "o1::4", "versions.1", "versions.4"}
+	if got := formatPrunedVersions(actions); !slices.Equal(got, want) {
+		t.Fatalf("expected %v to be pruned, got %v", want, got)
+	}
+
+	e = newHistoryEvent("o1", 20)
+	if actions, err = m.mapEvent(target, &e); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"o1::9", "versions.9"}
+	if got := formatPrunedVersions(actions); !slices.Equal(got, want) {
+		t.Fatalf("expected %v to be pruned, got %v", want, got)
+	}
+	if target.reads != 1 {
+		t.Fatalf("expected the versions of the written pointer to be cached, got %v reads", target.reads)
+	}
+}
+
+func TestHistoryMapper_PrunesAtMostMaxPrunedVersions(t *testing.T) {
+	cfg := testHistory
+	cfg.Retention.Count = 1
+	m, err := newHistoryMapper(cfg, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := make([]uint64, 0, 20)
+	for version := uint64(1); version <= 20; version++ {
+		versions = append(versions, version)
+	}
+	m.versions.store("o1", versions)
+
+	e := newHistoryEvent("o1", 21)
+	actions, err := m.mapEvent(nil, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointer := actions[len(actions)-1]
+	if len(pointer.PathValues) != 2+maxPrunedVersions || len(actions) != 2+maxPrunedVersions {
+		t.Fatalf("expected %v pruned versions, got %v", maxPrunedVersions, formatPrunedVersions(actions))
+	}
+	if kept, _ := m.versions.get("o1"); len(kept) != 21-maxPrunedVersions || kept[0] != maxPrunedVersions+1 {
+		t.Fatalf("expected the versions after the pruned ones to be kept, got %v", kept)
+	}
+}
+
+func TestHistoryMapper_MissingPointer(t *testing.T) {
+	m, err := newHistoryMapper(testHistory, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newHistoryEvent("o1", 1)
+	actions, err := m.mapEvent(&pathTargetClient{}, &e)
+	if err != nil || len(formatPrunedVersions(actions)) != 0 {
+		t.Fatalf("expected nothing to be pruned from a missing pointer, got %v %v", formatPrunedVersions(actions), err)
+	}
+}
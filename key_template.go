@@ -0,0 +1,176 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+const valuePlaceholderPrefix = "value."
+
+var jsonNumber = jsoniter.Config{UseNumber: true, SortMapKeys: true}.Froze()
+
+// keyTemplate renders keys like `product::{collection}::{key}`.
+// Supported placeholders are key, collection, vbId, seqNo, revNo, cas, `eventTime:<go time layout>`
+// and `value.<dotted json path>`.
+type keyTemplate struct {
+	parts      []keyTemplatePart
+	usesValues bool
+}
+
+type keyTemplatePart struct {
+	literal     string
+	placeholder string
+	argument    string
+	path        []string
+}
+
+func compileKeyTemplate(template string) (*keyTemplate, error) {
+	t := &keyTemplate{}
+	rest := template
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, keyTemplatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, keyTemplatePart{literal: rest[:start]})
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key template %q has an unclosed placeholder", template)
+		}
+
+		part, err := compileKeyTemplatePlaceholder(rest[start+1 : start+end])
+		if err != nil {
+			return nil, fmt.Errorf("key template %q: %w", template, err)
+		}
+		if part.path != nil {
+			t.usesValues = true
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[start+end+1:]
+	}
+	return t, nil
+}
+
+func compileKeyTemplatePlaceholder(placeholder string) (keyTemplatePart, error) {
+	name, argument, _ := strings.Cut(placeholder, ":")
+	switch {
+	case name == "key", name == "collection", name == "vbId", name == "seqNo", name == "revNo", name == "cas":
+		return keyTemplatePart{placeholder: name}, nil
+	case name == "eventTime":
+		if argument == "" {
+			argument = "2006-01-02T15:04:05Z07:00"
+		}
+		return keyTemplatePart{placeholder: name, argument: argument}, nil
+	case strings.HasPrefix(placeholder, valuePlaceholderPrefix):
+		return keyTemplatePart{placeholder: placeholder, path: splitJSONPath(placeholder[len(valuePlaceholderPrefix):])}, nil
+	default:
+		return keyTemplatePart{}, fmt.Errorf("unknown placeholder {%s}", placeholder)
+	}
+}
+
+func (t *keyTemplate) execute(e *couchbase.Event, doc map[string]any) ([]byte, error) {
+	var sb strings.Builder
+	for _, part := range t.parts {
+		switch part.placeholder {
+		case "":
+			sb.WriteString(part.literal)
+		case "key":
+			sb.Write(e.Key)
+		case "collection":
+			sb.WriteString(e.CollectionName)
+		case "vbId":
+			sb.WriteString(strconv.FormatUint(uint64(e.VbID), 10))
+		case "seqNo":
+			sb.WriteString(strconv.FormatUint(e.SeqNo, 10))
+		case "revNo":
+			sb.WriteString(strconv.FormatUint(e.RevNo, 10))
+		case "cas":
+			sb.WriteString(strconv.FormatUint(e.Cas, 10))
+		case "eventTime":
+			sb.WriteString(e.EventTime.UTC().Format(part.argument))
+		default:
+			value, ok := getJSONPath(doc, part.path)
+			if !ok {
+				return nil, fmt.Errorf("key template placeholder {%s} is missing in the value", part.placeholder)
+			}
+			sb.WriteString(jsonScalarString(value))
+		}
+	}
+	return []byte(sb.String()), nil
+}
+
+func jsonScalarString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "null"
+	default:
+		b, _ := jsonNumber.Marshal(v)
+		return string(b)
+	}
+}
+
+func decodeJSONObject(value []byte) (map[string]any, error) {
+	var doc map[string]any
+	if err := jsonNumber.Unmarshal(value, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, errors.New("value is not a json object")
+	}
+	return doc, nil
+}
+
+func splitJSONPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+func getJSONPath(doc map[string]any, path []string) (any, bool) {
+	var current any = doc
+	for _, name := range path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func setJSONPath(doc map[string]any, path []string, value any) {
+	object := doc
+	for _, name := range path[:len(path)-1] {
+		child, ok := object[name].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			object[name] = child
+		}
+		object = child
+	}
+	object[path[len(path)-1]] = value
+}
+
+func deleteJSONPath(doc map[string]any, path []string) {
+	object := doc
+	for _, name := range path[:len(path)-1] {
+		child, ok := object[name].(map[string]any)
+		if !ok {
+			return
+		}
+		object = child
+	}
+	delete(object, path[len(path)-1])
+}
@@ -4,12 +4,24 @@ import "github.com/Trendyol/go-dcp-couchbase/couchbase"
 
 type Mapper func(ctx couchbase.EventContext) []couchbase.CBActionDocument
 
+// MapperE is a Mapper which can fail, errors are handled by the configured `couchbase.mapperErrorPolicy`.
+type MapperE func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error)
+
+func (m Mapper) toMapperE() MapperE {
+	return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		return m(ctx), nil
+	}
+}
+
 func DefaultMapper(ctx couchbase.EventContext) []couchbase.CBActionDocument {
 	defaultMapperRootTrace := ctx.CreateChildTrace("DefaultMapper", map[string]interface{}{})
 	defer defaultMapperRootTrace.Finish()
 
 	if ctx.IsMutated {
-		return []couchbase.CBActionDocument{couchbase.NewSetAction(ctx.Key, ctx.Value)}
+		action := couchbase.NewSetAction(ctx.Key, ctx.Value)
+		action.SetDocumentFlags(ctx.Flags)
+		action.SetExpiry(ctx.Expiry)
+		return []couchbase.CBActionDocument{action}
 	}
 	return []couchbase.CBActionDocument{couchbase.NewDeleteAction(ctx.Key)}
 }
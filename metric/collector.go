@@ -1,6 +1,8 @@
 package metric
 
 import (
+	"strconv"
+
 	"github.com/Trendyol/go-dcp-couchbase/couchbase"
 	"github.com/Trendyol/go-dcp/helpers"
 	"github.com/prometheus/client_golang/prometheus"
@@ -9,12 +11,27 @@ import (
 type Collector struct {
 	processor                 *couchbase.Processor
 	getMapperProcessLatencyMs func() int64
+	getMapperErrorCount       func() int64
+	getFilteredEventCounts    func() map[string]int64
+	collectors                []prometheus.Collector
 
 	processLatency            *prometheus.Desc
 	mapperProcessLatency      *prometheus.Desc
+	mapperError               *prometheus.Desc
+	filteredEvent             *prometheus.Desc
 	bulkRequestProcessLatency *prometheus.Desc
 	bulkRequestSize           *prometheus.Desc
 	bulkRequestByteSize       *prometheus.Desc
+	targetClientCacheHit      *prometheus.Desc
+	targetClientCacheMiss     *prometheus.Desc
+	targetClientCacheSize     *prometheus.Desc
+	action                    *prometheus.Desc
+	ignoredStatus             *prometheus.Desc
+	bufferedAction            *prometheus.Desc
+	bufferedActionByteSize    *prometheus.Desc
+	oldestUnflushedEventAge   *prometheus.Desc
+	sinceLastFlush            *prometheus.Desc
+	lastWrittenSeqNo          *prometheus.Desc
 }
 
 func (s *Collector) Describe(ch chan<- *prometheus.Desc) {
@@ -22,22 +39,54 @@ func (s *Collector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (s *Collector) Collect(ch chan<- prometheus.Metric) {
-	processorMetric := s.processor.GetMetric()
+	s.collectMapper(ch)
+	s.collectProcessor(ch)
+	s.collectLag(ch)
+
+	for _, collector := range s.collectors {
+		collector.Collect(ch)
+	}
+	for _, histogram := range s.processor.GetHistograms() {
+		histogram.Collect(ch)
+	}
+
+	s.collectTargetClientCache(ch)
+}
 
+func (s *Collector) collectMapper(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
-		s.processLatency,
+		s.mapperProcessLatency,
 		prometheus.GaugeValue,
-		float64(processorMetric.ProcessLatencyMs),
+		float64(s.getMapperProcessLatencyMs()),
 		[]string{}...,
 	)
 
 	ch <- prometheus.MustNewConstMetric(
-		s.mapperProcessLatency,
-		prometheus.GaugeValue,
-		float64(s.getMapperProcessLatencyMs()),
+		s.mapperError,
+		prometheus.CounterValue,
+		float64(s.getMapperErrorCount()),
 		[]string{}...,
 	)
 
+	for filter, count := range s.getFilteredEventCounts() {
+		ch <- prometheus.MustNewConstMetric(
+			s.filteredEvent,
+			prometheus.CounterValue,
+			float64(count),
+			filter,
+		)
+	}
+}
+
+func (s *Collector) collectProcessor(ch chan<- prometheus.Metric) {
+	processorMetric := s.processor.GetMetric()
+
+	ch <- prometheus.MustNewConstMetric(
+		s.processLatency,
+		prometheus.GaugeValue,
+		float64(processorMetric.ProcessLatencyMs),
+		[]string{}...,
+	)
 	ch <- prometheus.MustNewConstMetric(
 		s.bulkRequestProcessLatency,
 		prometheus.GaugeValue,
@@ -56,44 +105,140 @@ func (s *Collector) Collect(ch chan<- prometheus.Metric) {
 		float64(processorMetric.BulkRequestByteSize),
 		[]string{}...,
 	)
+
+	for key, count := range s.processor.GetActionCounts() {
+		ch <- prometheus.MustNewConstMetric(
+			s.action,
+			prometheus.CounterValue,
+			float64(count),
+			string(key.ActionType), key.CollectionName, key.Outcome,
+		)
+	}
+	for status, count := range s.processor.GetIgnoredStatusCounts() {
+		ch <- prometheus.MustNewConstMetric(
+			s.ignoredStatus,
+			prometheus.CounterValue,
+			float64(count),
+			status,
+		)
+	}
+}
+
+func (s *Collector) collectLag(ch chan<- prometheus.Metric) {
+	lagMetric := s.processor.GetLagMetric()
+
+	ch <- prometheus.MustNewConstMetric(
+		s.bufferedAction,
+		prometheus.GaugeValue,
+		float64(lagMetric.BufferedActions),
+		[]string{}...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.bufferedActionByteSize,
+		prometheus.GaugeValue,
+		float64(lagMetric.BufferedByteSize),
+		[]string{}...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.oldestUnflushedEventAge,
+		prometheus.GaugeValue,
+		float64(lagMetric.OldestUnflushedAge.Milliseconds()),
+		[]string{}...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.sinceLastFlush,
+		prometheus.GaugeValue,
+		float64(lagMetric.SinceLastFlush.Milliseconds()),
+		[]string{}...,
+	)
+	for vbID, seqNo := range lagMetric.LastWrittenSeqNos {
+		ch <- prometheus.MustNewConstMetric(
+			s.lastWrittenSeqNo,
+			prometheus.GaugeValue,
+			float64(seqNo),
+			strconv.Itoa(int(vbID)),
+		)
+	}
+}
+
+func (s *Collector) collectTargetClientCache(ch chan<- prometheus.Metric) {
+	cache := s.processor.GetTargetClientCache()
+	if cache == nil {
+		return
+	}
+	cacheMetric := cache.GetMetric()
+
+	ch <- prometheus.MustNewConstMetric(
+		s.targetClientCacheHit,
+		prometheus.CounterValue,
+		float64(cacheMetric.Hits),
+		[]string{}...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.targetClientCacheMiss,
+		prometheus.CounterValue,
+		float64(cacheMetric.Misses),
+		[]string{}...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		s.targetClientCacheSize,
+		prometheus.GaugeValue,
+		float64(cacheMetric.Size),
+		[]string{}...,
+	)
 }
 
-func NewMetricCollector(processor *couchbase.Processor, getMapperProcessLatencyMs func() int64) *Collector {
+func NewMetricCollector(
+	processor *couchbase.Processor,
+	getMapperProcessLatencyMs func() int64,
+	getMapperErrorCount func() int64,
+	getFilteredEventCounts func() map[string]int64,
+	collectors ...prometheus.Collector,
+) *Collector {
 	return &Collector{
 		processor:                 processor,
 		getMapperProcessLatencyMs: getMapperProcessLatencyMs,
+		getMapperErrorCount:       getMapperErrorCount,
+		getFilteredEventCounts:    getFilteredEventCounts,
+		collectors:                collectors,
 
-		processLatency: prometheus.NewDesc(
-			prometheus.BuildFQName(helpers.Name, "couchbase_connector_latency_ms", "current"),
-			"Couchbase connector latency ms",
-			[]string{},
-			nil,
-		),
-
-		mapperProcessLatency: prometheus.NewDesc(
-			prometheus.BuildFQName(helpers.Name, "couchbase_connector_mapper_latency_ms", "current"),
-			"Couchbase connector mapper latency ms",
-			[]string{},
-			nil,
-		),
-
-		bulkRequestProcessLatency: prometheus.NewDesc(
-			prometheus.BuildFQName(helpers.Name, "couchbase_connector_bulk_request_process_latency_ms", "current"),
-			"Couchbase connector bulk request process latency ms",
-			[]string{},
-			nil,
-		),
-		bulkRequestSize: prometheus.NewDesc(
-			prometheus.BuildFQName(helpers.Name, "couchbase_connector_bulk_request_size", "current"),
-			"Couchbase connector bulk request size",
-			[]string{},
-			nil,
-		),
-		bulkRequestByteSize: prometheus.NewDesc(
-			prometheus.BuildFQName(helpers.Name, "couchbase_connector_bulk_request_byte_size", "current"),
-			"Couchbase connector bulk request byte size",
-			[]string{},
-			nil,
-		),
+		processLatency: newDesc("couchbase_connector_latency_ms", "current",
+			"Couchbase connector latency ms"),
+		mapperProcessLatency: newDesc("couchbase_connector_mapper_latency_ms", "current",
+			"Couchbase connector mapper latency ms"),
+		mapperError: newDesc("couchbase_connector_mapper_error", "total",
+			"Couchbase connector mapper error count"),
+		filteredEvent: newDesc("couchbase_connector_filtered_event", "total",
+			"Couchbase connector count of events dropped by the event filter", "filter"),
+		bulkRequestProcessLatency: newDesc("couchbase_connector_bulk_request_process_latency_ms", "current",
+			"Couchbase connector bulk request process latency ms"),
+		bulkRequestSize: newDesc("couchbase_connector_bulk_request_size", "current",
+			"Couchbase connector bulk request size"),
+		bulkRequestByteSize: newDesc("couchbase_connector_bulk_request_byte_size", "current",
+			"Couchbase connector bulk request byte size"),
+		targetClientCacheHit: newDesc("couchbase_connector_target_client_cache_hit", "total",
+			"Couchbase connector target client cache hit count"),
+		targetClientCacheMiss: newDesc("couchbase_connector_target_client_cache_miss", "total",
+			"Couchbase connector target client cache miss count"),
+		targetClientCacheSize: newDesc("couchbase_connector_target_client_cache_size", "current",
+			"Couchbase connector target client cache entry count"),
+		action: newDesc("couchbase_connector_action", "total",
+			"Couchbase connector written action count by outcome", "action_type", "collection", "outcome"),
+		ignoredStatus: newDesc("couchbase_connector_ignored_status", "total",
+			"Couchbase connector count of error statuses handled as success", "status"),
+		bufferedAction: newDesc("couchbase_connector_buffered_action", "current",
+			"Couchbase connector count of actions waiting to be written"),
+		bufferedActionByteSize: newDesc("couchbase_connector_buffered_action_byte_size", "current",
+			"Couchbase connector byte size of actions waiting to be written"),
+		oldestUnflushedEventAge: newDesc("couchbase_connector_oldest_unflushed_event_age_ms", "current",
+			"Couchbase connector age of the oldest event whose actions are not written yet"),
+		sinceLastFlush: newDesc("couchbase_connector_since_last_flush_ms", "current",
+			"Couchbase connector time since the buffer was last emptied by a successful write"),
+		lastWrittenSeqNo: newDesc("couchbase_connector_last_written_seq_no", "current",
+			"Couchbase connector sequence number of the last source event written to the target", "vbucket"),
 	}
 }
+
+func newDesc(name string, suffix string, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(helpers.Name, name, suffix), help, labels, nil)
+}
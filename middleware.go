@@ -0,0 +1,102 @@
+package dcpcouchbase
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+// Middleware wraps a mapper. It can inspect or alter the EventContext, short-circuit by not calling next,
+// or post-process the returned actions. Middlewares do not apply to a BatchMapper.
+type Middleware func(next MapperE) MapperE
+
+// chainMiddlewares wraps mapper so the first middleware is the outermost one.
+func chainMiddlewares(mapper MapperE, middlewares []Middleware) MapperE {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		mapper = middlewares[i](mapper)
+	}
+	return mapper
+}
+
+// RecoverMiddleware turns a mapper panic into an error, which is handled by `couchbase.mapperErrorPolicy`,
+// so the `handler` policy works as a dead letter queue for events crashing the mapper.
+func RecoverMiddleware() Middleware {
+	return func(next MapperE) MapperE {
+		return func(ctx couchbase.EventContext) (actions []couchbase.CBActionDocument, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					actions = nil
+					err = fmt.Errorf("mapper panic: %v\n%s", r, debug.Stack())
+				}
+			}()
+			return next(ctx)
+		}
+	}
+}
+
+// KeyPrefixFilterMiddleware skips events whose key does not start with one of the prefixes.
+func KeyPrefixFilterMiddleware(prefixes ...string) Middleware {
+	return func(next MapperE) MapperE {
+		return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+			for _, prefix := range prefixes {
+				if bytes.HasPrefix(ctx.Key, []byte(prefix)) {
+					return next(ctx)
+				}
+			}
+			return nil, nil
+		}
+	}
+}
+
+// KeyPatternFilterMiddleware skips events whose key does not match the pattern.
+func KeyPatternFilterMiddleware(pattern *regexp.Regexp) Middleware {
+	return func(next MapperE) MapperE {
+		return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+			if !pattern.Match(ctx.Key) {
+				return nil, nil
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// KeyPrefixMiddleware prepends prefix to the id of every returned action.
+func KeyPrefixMiddleware(prefix string) Middleware {
+	return ActionStampMiddleware(func(_ couchbase.EventContext, action *couchbase.CBActionDocument) {
+		id := make([]byte, 0, len(prefix)+len(action.ID))
+		action.ID = append(append(id, prefix...), action.ID...)
+		action.Size += len(prefix)
+	})
+}
+
+// ActionStampMiddleware calls stamp for every returned action, e.g. to set expiry, flags or target collection.
+func ActionStampMiddleware(stamp func(ctx couchbase.EventContext, action *couchbase.CBActionDocument)) Middleware {
+	return func(next MapperE) MapperE {
+		return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+			actions, err := next(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for i := range actions {
+				stamp(ctx, &actions[i])
+			}
+			return actions, nil
+		}
+	}
+}
+
+// TraceMiddleware wraps the rest of the chain in a child trace of the listener trace.
+func TraceMiddleware(operationName string) Middleware {
+	return func(next MapperE) MapperE {
+		return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+			trace := ctx.CreateChildTrace(operationName, map[string]interface{}{
+				"key": string(ctx.Key),
+			})
+			defer trace.Finish()
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Trendyol/go-dcp/tracing"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+func newMiddlewareContext(key string) couchbase.EventContext {
+	return couchbase.EventContext{Event: newTapEvent(key, "orders", `{}`), ListenerTrace: noopListenerTrace{}}
+}
+
+func setMapper(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+	return []couchbase.CBActionDocument{couchbase.NewSetAction(ctx.Key, []byte(`{}`))}, nil
+}
+
+func TestChainMiddlewares(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next MapperE) MapperE {
+			return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+				calls = append(calls, name+" before")
+				actions, err := next(ctx)
+				calls = append(calls, name+" after")
+				return actions, err
+			}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		middlewares []Middleware
+		want        []string
+	}{
+		{name: "no middleware", want: []string{"mapper"}},
+		{name: "one middleware", middlewares: []Middleware{record("a")}, want: []string{"a before", "mapper", "a after"}},
+		{
+			name:        "first middleware is the outermost",
+			middlewares: []Middleware{record("a"), record("b"), record("c")},
+			want:        []string{"a before", "b before", "c before", "mapper", "c after", "b after", "a after"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			mapper := chainMiddlewares(func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+				calls = append(calls, "mapper")
+				return setMapper(ctx)
+			}, tt.middlewares)
+
+			if _, err := mapper(newMiddlewareContext("k")); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, calls)
+			}
+		})
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	mapperErr := errors.New("can not map")
+
+	tests := []struct {
+		mapper  MapperE
+		name    string
+		wantErr string
+		actions int
+	}{
+		{name: "actions pass through", mapper: setMapper, actions: 1},
+		{
+			name: "error passes through",
+			mapper: func(couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+				return nil, mapperErr
+			},
+			wantErr: mapperErr.Error(),
+		},
+		{
+			name: "panic becomes an error",
+			mapper: func(couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+				panic("boom")
+			},
+			wantErr: "mapper panic: boom\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := RecoverMiddleware()(tt.mapper)(newMiddlewareContext("k"))
+			if len(actions) != tt.actions {
+				t.Fatalf("expected %v actions, got %v", tt.actions, actions)
+			}
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.HasPrefix(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestKeyFilterMiddlewares(t *testing.T) {
+	tests := []struct {
+		middleware Middleware
+		name       string
+		key        string
+		mapped     bool
+	}{
+		{name: "prefix matches", middleware: KeyPrefixFilterMiddleware("order:", "user:"), key: "user:1", mapped: true},
+		{name: "prefix does not match", middleware: KeyPrefixFilterMiddleware("order:", "user:"), key: "product:1"},
+		{name: "no prefix skips every event", middleware: KeyPrefixFilterMiddleware(), key: "order:1"},
+		{name: "pattern matches", middleware: KeyPatternFilterMiddleware(regexp.MustCompile(`^order:\d+$`)), key: "order:1", mapped: true},
+		{name: "pattern does not match", middleware: KeyPatternFilterMiddleware(regexp.MustCompile(`^order:\d+$`)), key: "order:x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := tt.middleware(setMapper)(newMiddlewareContext(tt.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mapped := len(actions) == 1; mapped != tt.mapped {
+				t.Fatalf("expected mapped %v, got %v", tt.mapped, actions)
+			}
+		})
+	}
+}
+
+func TestKeyPrefixMiddleware(t *testing.T) {
+	key := []byte("k")
+	pathValues := []couchbase.PathValue{{Op: couchbase.PathUpsert, Path: []byte("a"), Value: []byte(`1`)}}
+	actions := []couchbase.CBActionDocument{
+		couchbase.NewSetAction(key, []byte(`{}`)),
+		couchbase.NewDeleteAction(key),
+		couchbase.NewMutateInAction(key, []byte("a"), []byte(`1`)),
+		couchbase.NewMultiMutateInAction(key, pathValues),
+		couchbase.NewDeletePathAction(key, []byte("a")),
+		couchbase.NewArrayAppendAction(key, []byte("a"), []byte(`1`)),
+		couchbase.NewIncrementAction(key, 1, 1),
+		couchbase.NewMinMaxAction(key, pathValues),
+	}
+
+	for _, action := range actions {
+		t.Run(string(action.Type), func(t *testing.T) {
+			mapper := KeyPrefixMiddleware("mirror::")(func(couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+				return []couchbase.CBActionDocument{action}, nil
+			})
+
+			got, err := mapper(newMiddlewareContext("k"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := action
+			want.ID = []byte("mirror::k")
+			want.Size += len("mirror::")
+			if len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+				t.Fatalf("expected %+v, got %+v", want, got)
+			}
+			if string(action.ID) != "k" {
+				t.Fatalf("expected the key of the mapper action to be kept, got %s", action.ID)
+			}
+		})
+	}
+}
+
+func TestActionStampMiddleware_Error(t *testing.T) {
+	mapperErr := errors.New("can not map")
+	stamped := false
+	mapper := ActionStampMiddleware(func(couchbase.EventContext, *couchbase.CBActionDocument) {
+		stamped = true
+	})(func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		actions, _ := setMapper(ctx)
+		return actions, mapperErr
+	})
+
+	actions, err := mapper(newMiddlewareContext("k"))
+	if actions != nil || err != mapperErr || stamped {
+		t.Fatalf("expected the error without stamped actions, got %v %v %v", actions, err, stamped)
+	}
+}
+
+type recordingListenerTrace struct {
+	attributes map[string]interface{}
+	name       string
+	finished   bool
+}
+
+func (r *recordingListenerTrace) CreateChildTrace(name string, attributes map[string]interface{}) tracing.ListenerTrace {
+	r.name, r.attributes = name, attributes
+	return r
+}
+
+func (r *recordingListenerTrace) Finish() {
+	r.finished = true
+}
+
+func TestTraceMiddleware(t *testing.T) {
+	trace := &recordingListenerTrace{}
+	ctx := newMiddlewareContext("order:1")
+	ctx.ListenerTrace = trace
+
+	finishedBeforeMapper := true
+	mapper := TraceMiddleware("Mapper")(func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		finishedBeforeMapper = trace.finished
+		return setMapper(ctx)
+	})
+
+	if _, err := mapper(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if trace.name != "Mapper" || trace.attributes["key"] != "order:1" || finishedBeforeMapper || !trace.finished {
+		t.Fatalf("expected a Mapper trace of the key finished after the mapper, got %+v", trace)
+	}
+}
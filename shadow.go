@@ -0,0 +1,258 @@
+package dcpcouchbase
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	"github.com/Trendyol/go-dcp/helpers"
+	"github.com/Trendyol/go-dcp/logger"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	shadowResultMatch    = "match"
+	shadowResultDiverged = "diverged"
+	shadowResultError    = "error"
+
+	shadowDivergenceMissing = "missing"
+	shadowDivergenceExtra   = "extra"
+	shadowDivergenceChanged = "changed"
+)
+
+// shadowActionKey identifies an action of the primary or shadow mapper output before collection routing.
+type shadowActionKey struct {
+	actionType     couchbase.CbAction
+	id             string
+	scopeName      string
+	collectionName string
+	path           string
+}
+
+type shadowMapper struct {
+	mapper      MapperE
+	events      *prometheus.CounterVec
+	divergences *prometheus.CounterVec
+	writeErrors prometheus.Counter
+	cfg         config.Shadow
+}
+
+// newShadowMapper runs shadow next to primary for every event and compares their actions. In the write mode the shadow
+// actions are also written to the shadow collection, a failing or panicking shadow mapper and failing shadow writes
+// never affect the primary one.
+func newShadowMapper(primary MapperE, shadow MapperE, cfg config.Shadow) (MapperE, []prometheus.Collector, error) {
+	switch cfg.Mode {
+	case config.ShadowModeCompare:
+	case config.ShadowModeWrite:
+		if cfg.CollectionName == "" {
+			return nil, nil, errors.New("couchbase.shadow.collectionName is needed by the write mode")
+		}
+	default:
+		return nil, nil, fmt.Errorf("couchbase.shadow.mode: unexpected value %q", cfg.Mode)
+	}
+
+	m := &shadowMapper{
+		mapper: shadow,
+		cfg:    cfg,
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(helpers.Name, "couchbase_connector_shadow_event", "total"),
+			Help: "Couchbase connector count of events compared with the shadow mapper by result",
+		}, []string{"result"}),
+		divergences: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(helpers.Name, "couchbase_connector_shadow_divergence", "total"),
+			Help: "Couchbase connector count of actions differing between the primary and the shadow mapper",
+		}, []string{"kind"}),
+		writeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prometheus.BuildFQName(helpers.Name, "couchbase_connector_shadow_write_error", "total"),
+			Help: "Couchbase connector count of shadow actions failed to write",
+		}),
+	}
+
+	return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		actions, err := primary(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return append(actions, m.run(ctx, actions)...), nil
+	}, []prometheus.Collector{m.events, m.divergences, m.writeErrors}, nil
+}
+
+// run compares the shadow actions with the primary ones and returns the shadow actions to write.
+func (m *shadowMapper) run(ctx couchbase.EventContext, primary []couchbase.CBActionDocument) []couchbase.CBActionDocument {
+	shadowTrace := ctx.CreateChildTrace("ShadowMapper", map[string]interface{}{})
+	defer shadowTrace.Finish()
+
+	shadow, err := m.mapShadow(ctx)
+	if err != nil {
+		m.events.WithLabelValues(shadowResultError).Inc()
+		if m.sampled() {
+			logger.Log.Warn("shadow mapper error, key: %s, err: %v", ctx.Key, err)
+		}
+		return nil
+	}
+
+	divergences := compareActions(primary, shadow)
+	if len(divergences) == 0 {
+		m.events.WithLabelValues(shadowResultMatch).Inc()
+	} else {
+		m.events.WithLabelValues(shadowResultDiverged).Inc()
+		for _, kind := range divergences {
+			m.divergences.WithLabelValues(kind).Inc()
+		}
+		if m.sampled() {
+			logger.Log.Warn("shadow mapper diverged, key: %s, divergences: %v", ctx.Key, divergences)
+		}
+	}
+
+	if m.cfg.Mode != config.ShadowModeWrite {
+		return nil
+	}
+	for i := range shadow {
+		m.route(&shadow[i])
+		shadow[i].SetBestEffort(true)
+		shadow[i].AddWrittenCallback(m.writeCallback(shadow[i].ID))
+	}
+	return shadow
+}
+
+// route writes the shadow actions routed to a collection to the same collection under the shadow prefix,
+// so they do not collide with the actions of other collections. The others are written to the shadow collection.
+func (m *shadowMapper) route(action *couchbase.CBActionDocument) {
+	if action.CollectionName == "" {
+		action.SetScopeName(m.cfg.ScopeName)
+		action.SetCollectionName(m.cfg.CollectionName)
+		return
+	}
+
+	if action.ScopeName == "" {
+		action.SetScopeName(m.cfg.ScopeName)
+	}
+	action.SetCollectionName(m.cfg.CollectionPrefix + action.CollectionName)
+}
+
+// mapShadow runs the shadow mapper, a panic is returned as an error.
+func (m *shadowMapper) mapShadow(ctx couchbase.EventContext) (actions []couchbase.CBActionDocument, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			actions, err = nil, fmt.Errorf("shadow mapper panic: %v", r)
+		}
+	}()
+	return m.mapper(ctx)
+}
+
+func (m *shadowMapper) writeCallback(id []byte) func(err error) {
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		m.writeErrors.Inc()
+		if m.sampled() {
+			logger.Log.Warn("shadow action write error, key: %s, err: %v", id, err)
+		}
+	}
+}
+
+func (m *shadowMapper) sampled() bool {
+	return m.cfg.LogSampleRate > 0 && rand.Float64() < m.cfg.LogSampleRate
+}
+
+// compareActions returns the divergence kind of every differing action, values are compared as JSON when both are JSON.
+// Actions with the same key are matched as a multiset, a primary action is paired with an equal shadow action
+// of its key first and with the first unpaired one otherwise.
+func compareActions(primary []couchbase.CBActionDocument, shadow []couchbase.CBActionDocument) []string {
+	shadowActions := make(map[shadowActionKey][]*couchbase.CBActionDocument, len(shadow))
+	for i := range shadow {
+		key := newShadowActionKey(&shadow[i])
+		shadowActions[key] = append(shadowActions[key], &shadow[i])
+	}
+
+	var divergences []string
+	var changed []*couchbase.CBActionDocument
+	for i := range primary {
+		key := newShadowActionKey(&primary[i])
+		candidates := shadowActions[key]
+		if index := indexOfEqualAction(&primary[i], candidates); index >= 0 {
+			shadowActions[key] = append(candidates[:index], candidates[index+1:]...)
+		} else {
+			changed = append(changed, &primary[i])
+		}
+	}
+	for _, action := range changed {
+		key := newShadowActionKey(action)
+		if candidates := shadowActions[key]; len(candidates) > 0 {
+			shadowActions[key] = candidates[1:]
+			divergences = append(divergences, shadowDivergenceChanged)
+		} else {
+			divergences = append(divergences, shadowDivergenceMissing)
+		}
+	}
+	for _, candidates := range shadowActions {
+		for range candidates {
+			divergences = append(divergences, shadowDivergenceExtra)
+		}
+	}
+	return divergences
+}
+
+func indexOfEqualAction(action *couchbase.CBActionDocument, candidates []*couchbase.CBActionDocument) int {
+	for i, candidate := range candidates {
+		if equalActions(action, candidate) {
+			return i
+		}
+	}
+	return -1
+}
+
+func newShadowActionKey(action *couchbase.CBActionDocument) shadowActionKey {
+	return shadowActionKey{
+		actionType:     action.Type,
+		id:             string(action.ID),
+		scopeName:      action.ScopeName,
+		collectionName: action.CollectionName,
+		path:           string(action.Path),
+	}
+}
+
+// equalActions compares every field affecting the write of two actions with the same shadowActionKey.
+func equalActions(primary *couchbase.CBActionDocument, shadow *couchbase.CBActionDocument) bool {
+	if primary.DocumentFlags != shadow.DocumentFlags || primary.Expiry != shadow.Expiry ||
+		primary.PreserveExpiry != shadow.PreserveExpiry || primary.DisableAutoCreate != shadow.DisableAutoCreate ||
+		primary.StrictPaths != shadow.StrictPaths || primary.Delta != shadow.Delta || primary.Initial != shadow.Initial ||
+		!equalCas(primary.Cas, shadow.Cas) || len(primary.PathValues) != len(shadow.PathValues) ||
+		!equalValues(primary.Source, shadow.Source) {
+		return false
+	}
+
+	for i := range primary.PathValues {
+		p, s := primary.PathValues[i], shadow.PathValues[i]
+		if p.Op != s.Op || !bytes.Equal(p.Path, s.Path) || p.Xattr != s.Xattr || p.CreateParents != s.CreateParents ||
+			!equalValues(p.Value, s.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalCas(primary *uint64, shadow *uint64) bool {
+	if primary == nil || shadow == nil {
+		return primary == shadow
+	}
+	return *primary == *shadow
+}
+
+func equalValues(primary []byte, shadow []byte) bool {
+	if bytes.Equal(primary, shadow) {
+		return true
+	}
+
+	var p, s any
+	if jsoniter.Unmarshal(primary, &p) != nil || jsoniter.Unmarshal(shadow, &s) != nil {
+		return false
+	}
+	return reflect.DeepEqual(p, s)
+}
@@ -0,0 +1,236 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+var compareActionsTests = []struct {
+	name    string
+	primary []couchbase.CBActionDocument
+	shadow  []couchbase.CBActionDocument
+	want    []string
+}{
+	{
+		name:    "equal",
+		primary: []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), []byte(`{"a":1,"b":2}`))},
+		shadow:  []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), []byte(`{"b":2,"a":1}`))},
+	},
+	{
+		name:    "changed value",
+		primary: []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), []byte(`{"a":1}`))},
+		shadow:  []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), []byte(`{"a":2}`))},
+		want:    []string{shadowDivergenceChanged},
+	},
+	{
+		name:    "missing and extra",
+		primary: []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k1"), []byte(`1`))},
+		shadow:  []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k2"), []byte(`1`))},
+		want:    []string{shadowDivergenceExtra, shadowDivergenceMissing},
+	},
+	{
+		name:    "different action type",
+		primary: []couchbase.CBActionDocument{couchbase.NewDeleteAction([]byte("k"))},
+		shadow:  []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), nil)},
+		want:    []string{shadowDivergenceExtra, shadowDivergenceMissing},
+	},
+	{
+		name: "repeated actions of a key in another order",
+		primary: []couchbase.CBActionDocument{
+			couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+			couchbase.NewSetAction([]byte("k"), []byte(`2`)),
+		},
+		shadow: []couchbase.CBActionDocument{
+			couchbase.NewSetAction([]byte("k"), []byte(`2`)),
+			couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+		},
+	},
+	{
+		name: "repeated actions of a key",
+		primary: []couchbase.CBActionDocument{
+			couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+			couchbase.NewSetAction([]byte("k"), []byte(`2`)),
+			couchbase.NewSetAction([]byte("k"), []byte(`3`)),
+		},
+		shadow: []couchbase.CBActionDocument{
+			couchbase.NewSetAction([]byte("k"), []byte(`3`)),
+			couchbase.NewSetAction([]byte("k"), []byte(`4`)),
+		},
+		want: []string{shadowDivergenceChanged, shadowDivergenceMissing},
+	},
+	{
+		name:    "repeated shadow actions",
+		primary: []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), []byte(`1`))},
+		shadow: []couchbase.CBActionDocument{
+			couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+			couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+		},
+		want: []string{shadowDivergenceExtra},
+	},
+}
+
+func TestCompareActions(t *testing.T) {
+	for _, tt := range compareActionsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareActions(tt.primary, tt.shadow)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func shadowPathValues(value string) []couchbase.PathValue {
+	return []couchbase.PathValue{{Op: couchbase.PathUpsert, Path: []byte("a.b"), Value: []byte(value)}}
+}
+
+// withAction returns action changed by set.
+func withAction(action couchbase.CBActionDocument, set func(action *couchbase.CBActionDocument)) couchbase.CBActionDocument {
+	set(&action)
+	return action
+}
+
+var equalActionsTests = []struct {
+	name    string
+	primary couchbase.CBActionDocument
+	shadow  couchbase.CBActionDocument
+	want    bool
+}{
+	{
+		name:    "json equal path values",
+		primary: couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`{"x":1,"y":[1,2]}`)),
+		shadow:  couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`{"y":[1,2],"x":1}`)),
+		want:    true,
+	},
+	{
+		name:    "different path values",
+		primary: couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`[1,2]`)),
+		shadow:  couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`[2,1]`)),
+	},
+	{
+		name:    "different path value count",
+		primary: couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)),
+		shadow:  couchbase.NewMultiMutateInAction([]byte("k"), nil),
+	},
+	{
+		name:    "equal non json values",
+		primary: couchbase.NewSetAction([]byte("k"), []byte("raw")),
+		shadow:  couchbase.NewSetAction([]byte("k"), []byte("raw")),
+		want:    true,
+	},
+	{
+		name:    "different non json values",
+		primary: couchbase.NewSetAction([]byte("k"), []byte("raw")),
+		shadow:  couchbase.NewSetAction([]byte("k"), []byte("raw2")),
+	},
+	{
+		name:    "different expiry",
+		primary: withAction(couchbase.NewSetAction([]byte("k"), []byte(`1`)), func(a *couchbase.CBActionDocument) { a.SetExpiry(10) }),
+		shadow:  couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+	},
+	{
+		name:    "different flags",
+		primary: withAction(couchbase.NewSetAction([]byte("k"), []byte(`1`)), func(a *couchbase.CBActionDocument) { a.SetDocumentFlags(1) }),
+		shadow:  couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+	},
+	{
+		name:    "different cas",
+		primary: withAction(couchbase.NewSetAction([]byte("k"), []byte(`1`)), func(a *couchbase.CBActionDocument) { a.SetCas(1) }),
+		shadow:  withAction(couchbase.NewSetAction([]byte("k"), []byte(`1`)), func(a *couchbase.CBActionDocument) { a.SetCas(2) }),
+	},
+	{
+		name:    "cas and no cas",
+		primary: withAction(couchbase.NewSetAction([]byte("k"), []byte(`1`)), func(a *couchbase.CBActionDocument) { a.SetCas(1) }),
+		shadow:  couchbase.NewSetAction([]byte("k"), []byte(`1`)),
+	},
+	{
+		name: "different auto create",
+		primary: withAction(couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)), func(a *couchbase.CBActionDocument) {
+			a.SetDisableAutoCreate(true)
+		}),
+		shadow: couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)),
+	},
+	{
+		name: "different path op",
+		primary: withAction(couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)), func(a *couchbase.CBActionDocument) {
+			a.PathValues[0].Op = couchbase.PathRemove
+		}),
+		shadow: couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)),
+	},
+	{
+		name: "different create parents",
+		primary: withAction(couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)), func(a *couchbase.CBActionDocument) {
+			a.PathValues[0].CreateParents = true
+		}),
+		shadow: couchbase.NewMultiMutateInAction([]byte("k"), shadowPathValues(`1`)),
+	},
+}
+
+func TestEqualActions(t *testing.T) {
+	for _, tt := range equalActionsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := equalActions(&tt.primary, &tt.shadow); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestShadowMapper_Panic(t *testing.T) {
+	m := &shadowMapper{mapper: func(couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		panic("boom")
+	}}
+
+	actions, err := m.mapShadow(couchbase.EventContext{})
+	if err == nil || err.Error() != "shadow mapper panic: boom" || actions != nil {
+		t.Fatalf("expected the panic as an error, got %v %v", actions, err)
+	}
+}
+
+func TestShadowMapper_WriteError(t *testing.T) {
+	m := &shadowMapper{writeErrors: prometheus.NewCounter(prometheus.CounterOpts{Name: "shadow_write_error_total"})}
+
+	m.writeCallback([]byte("k"))(nil)
+	if got := testutil.ToFloat64(m.writeErrors); got != 0 {
+		t.Fatalf("expected no write errors, got %v", got)
+	}
+	m.writeCallback([]byte("k"))(errors.New("write failed"))
+	if got := testutil.ToFloat64(m.writeErrors); got != 1 {
+		t.Fatalf("expected 1 write error, got %v", got)
+	}
+}
+
+func TestShadowMapper_Route(t *testing.T) {
+	m := &shadowMapper{cfg: config.Shadow{ScopeName: "shadow", CollectionName: "mirror", CollectionPrefix: "shadow_"}}
+
+	unrouted := couchbase.NewSetAction([]byte("k"), []byte(`1`))
+	routed := couchbase.NewSetAction([]byte("k"), []byte(`1`))
+	routed.SetCollectionName("orders")
+	scoped := couchbase.NewSetAction([]byte("k"), []byte(`1`))
+	scoped.SetScopeName("sales")
+	scoped.SetCollectionName("orders")
+
+	for _, tt := range []struct {
+		action     *couchbase.CBActionDocument
+		scope      string
+		collection string
+	}{
+		{action: &unrouted, scope: "shadow", collection: "mirror"},
+		{action: &routed, scope: "shadow", collection: "shadow_orders"},
+		{action: &scoped, scope: "sales", collection: "shadow_orders"},
+	} {
+		m.route(tt.action)
+		if tt.action.ScopeName != tt.scope || tt.action.CollectionName != tt.collection {
+			t.Fatalf("expected %s.%s, got %s.%s", tt.scope, tt.collection, tt.action.ScopeName, tt.action.CollectionName)
+		}
+	}
+}
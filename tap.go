@@ -0,0 +1,291 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+	"github.com/Trendyol/go-dcp/logger"
+	jsoniter "github.com/json-iterator/go"
+)
+
+type tapEvent struct {
+	Time           time.Time `json:"time"`
+	Type           string    `json:"type"`
+	Key            string    `json:"key"`
+	ScopeName      string    `json:"scope"`
+	CollectionName string    `json:"collection"`
+	Value          any       `json:"value,omitempty"`
+	Cas            uint64    `json:"cas"`
+	SeqNo          uint64    `json:"seqNo"`
+	VbID           uint16    `json:"vbId"`
+}
+
+type tapPathValue struct {
+	Value any    `json:"value,omitempty"`
+	Op    string `json:"op,omitempty"`
+	Path  string `json:"path"`
+}
+
+type tapAction struct {
+	Value          any            `json:"value,omitempty"`
+	Type           string         `json:"type"`
+	Key            string         `json:"key"`
+	ScopeName      string         `json:"scope,omitempty"`
+	CollectionName string         `json:"collection,omitempty"`
+	Path           string         `json:"path,omitempty"`
+	PathValues     []tapPathValue `json:"pathValues,omitempty"`
+}
+
+type tapRecord struct {
+	Event   tapEvent    `json:"event"`
+	Actions []tapAction `json:"actions"`
+	// Dropped is the count of records skipped for this subscriber since the previous one, it was too slow.
+	Dropped int64 `json:"dropped,omitempty"`
+}
+
+type tapSubscriber struct {
+	keyPattern  *regexp.Regexp
+	collections map[string]struct{}
+	records     chan tapRecord
+	dropped     atomic.Int64
+	sampleRate  float64
+	redact      bool
+}
+
+func (s *tapSubscriber) match(e *couchbase.Event) bool {
+	if len(s.collections) > 0 {
+		if _, ok := s.collections[e.CollectionName]; !ok {
+			return false
+		}
+	}
+	if s.keyPattern != nil && !s.keyPattern.Match(e.Key) {
+		return false
+	}
+	return s.sampleRate >= 1 || rand.Float64() < s.sampleRate
+}
+
+// actionTap streams mapped events and their actions to the subscribers of `/admin/tap`.
+type actionTap struct {
+	subscribers map[*tapSubscriber]struct{}
+	closeCh     chan struct{}
+	cfg         config.Tap
+	count       atomic.Int32
+	mu          sync.RWMutex
+}
+
+func newActionTap(cfg config.Tap) *actionTap {
+	return &actionTap{
+		subscribers: map[*tapSubscriber]struct{}{},
+		closeCh:     make(chan struct{}),
+		cfg:         cfg,
+	}
+}
+
+// publish costs a single atomic load while no subscriber is attached, records are dropped for slow subscribers.
+func (t *actionTap) publish(e *couchbase.Event, actions []couchbase.CBActionDocument) {
+	if t.count.Load() == 0 {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var redacted, full *tapRecord
+	for s := range t.subscribers {
+		if !s.match(e) {
+			continue
+		}
+
+		var record *tapRecord
+		if s.redact {
+			if redacted == nil {
+				redacted = newTapRecord(e, actions, true)
+			}
+			record = redacted
+		} else {
+			if full == nil {
+				full = newTapRecord(e, actions, false)
+			}
+			record = full
+		}
+
+		select {
+		case s.records <- *record:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (t *actionTap) subscribe(s *tapSubscriber) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.subscribers) >= t.cfg.MaxSubscribers {
+		return fmt.Errorf("tap has %v subscribers already", t.cfg.MaxSubscribers)
+	}
+	t.subscribers[s] = struct{}{}
+	t.count.Add(1)
+	return nil
+}
+
+func (t *actionTap) unsubscribe(s *tapSubscriber) {
+	t.mu.Lock()
+	delete(t.subscribers, s)
+	t.count.Add(-1)
+	t.mu.Unlock()
+}
+
+func (t *actionTap) close() {
+	close(t.closeCh)
+}
+
+func newTapRecord(e *couchbase.Event, actions []couchbase.CBActionDocument, redact bool) *tapRecord {
+	record := &tapRecord{
+		Event: tapEvent{
+			Time:           e.EventTime,
+			Type:           e.Type(),
+			Key:            string(e.Key),
+			ScopeName:      e.ScopeName,
+			CollectionName: e.CollectionName,
+			Value:          tapValue(e.Value, redact),
+			Cas:            e.Cas,
+			SeqNo:          e.SeqNo,
+			VbID:           e.VbID,
+		},
+		Actions: make([]tapAction, 0, len(actions)),
+	}
+
+	for i := range actions {
+		action := tapAction{
+			Value:          tapValue(actions[i].Source, redact),
+			Type:           string(actions[i].Type),
+			Key:            string(actions[i].ID),
+			ScopeName:      actions[i].ScopeName,
+			CollectionName: actions[i].CollectionName,
+			Path:           string(actions[i].Path),
+		}
+		for _, pathValue := range actions[i].PathValues {
+			action.PathValues = append(action.PathValues, tapPathValue{
+				Value: tapValue(pathValue.Value, redact),
+				Op:    string(pathValue.Op),
+				Path:  string(pathValue.Path),
+			})
+		}
+		record.Actions = append(record.Actions, action)
+	}
+	return record
+}
+
+// tapValue keeps JSON values as they are, other values are shown as strings and redacted values by their size.
+// Values are copied, the event buffers may be reused before a subscriber writes the record.
+func tapValue(value []byte, redact bool) any {
+	switch {
+	case len(value) == 0:
+		return nil
+	case redact:
+		return fmt.Sprintf("<redacted %v bytes>", len(value))
+	case jsoniter.Valid(value):
+		return jsoniter.RawMessage(append([]byte(nil), value...))
+	default:
+		return string(value)
+	}
+}
+
+// newTapSubscriber reads the `keyPattern`, `collection`, `sample` and `redact` query parameters.
+func (t *actionTap) newTapSubscriber(r *http.Request) (*tapSubscriber, error) {
+	query := r.URL.Query()
+	s := &tapSubscriber{
+		collections: toSet(query["collection"]),
+		records:     make(chan tapRecord, t.cfg.BufferSize),
+		sampleRate:  1,
+		redact:      !t.cfg.IncludeValues,
+	}
+
+	if keyPattern := query.Get("keyPattern"); keyPattern != "" {
+		pattern, err := regexp.Compile(keyPattern)
+		if err != nil {
+			return nil, fmt.Errorf("keyPattern: %w", err)
+		}
+		s.keyPattern = pattern
+	}
+	if sample := query.Get("sample"); sample != "" {
+		sampleRate, err := strconv.ParseFloat(sample, 64)
+		if err != nil || sampleRate <= 0 || sampleRate > 1 {
+			return nil, errors.New("sample must be in (0, 1]")
+		}
+		s.sampleRate = sampleRate
+	}
+	if query.Get("redact") == "true" {
+		s.redact = true
+	}
+	return s, nil
+}
+
+// serveTap streams records as server-sent events, or as JSON lines with `format=ndjson`.
+func (t *actionTap) serveTap(w http.ResponseWriter, r *http.Request) {
+	s, err := t.newTapSubscriber(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err = t.subscribe(s); err != nil {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	defer t.unsubscribe(s)
+
+	sse := r.URL.Query().Get("format") != "ndjson"
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	if err = controller.Flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case record := <-s.records:
+			record.Dropped = s.dropped.Swap(0)
+			if err = writeTapRecord(w, record, sse); err == nil {
+				err = controller.Flush()
+			}
+			if err != nil {
+				logger.Log.Debug("tap subscriber is gone, err: %v", err)
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-t.closeCh:
+			return
+		}
+	}
+}
+
+func writeTapRecord(w http.ResponseWriter, record tapRecord, sse bool) error {
+	line, err := jsoniter.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if sse {
+		_, err = fmt.Fprintf(w, "data: %s\n\n", line)
+	} else {
+		_, err = fmt.Fprintf(w, "%s\n", line)
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package dcpcouchbase
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/Trendyol/go-dcp-couchbase/config"
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+func newTapEvent(key string, collectionName string, value string) couchbase.Event {
+	return couchbase.NewMutateEvent([]byte(key), []byte(value), collectionName, time.Time{}, 1, 2, 3, 4)
+}
+
+func newTestTapSubscriber(t *testing.T, tap *actionTap, query string) *tapSubscriber {
+	t.Helper()
+
+	s, err := tap.newTapSubscriber(httptest.NewRequest(http.MethodGet, "/admin/tap?"+query, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tap.subscribe(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestActionTap_Filter(t *testing.T) {
+	tap := newActionTap(config.Tap{MaxSubscribers: 1, BufferSize: 10, IncludeValues: true})
+	s := newTestTapSubscriber(t, tap, "keyPattern=^order:&collection=orders")
+
+	for _, e := range []couchbase.Event{
+		newTapEvent("order:1", "orders", `{}`),
+		newTapEvent("user:1", "orders", `{}`),
+		newTapEvent("order:2", "users", `{}`),
+	} {
+		tap.publish(&e, nil)
+	}
+
+	if len(s.records) != 1 {
+		t.Fatalf("expected 1 record, got %v", len(s.records))
+	}
+	if record := <-s.records; record.Event.Key != "order:1" {
+		t.Fatalf("expected order:1, got %v", record.Event.Key)
+	}
+}
+
+func TestActionTap_Sample(t *testing.T) {
+	tap := newActionTap(config.Tap{MaxSubscribers: 1, BufferSize: 10})
+
+	for _, sample := range []string{"0", "1.5", "x"} {
+		request := httptest.NewRequest(http.MethodGet, "/admin/tap?sample="+sample, nil)
+		if _, err := tap.newTapSubscriber(request); err == nil {
+			t.Fatalf("expected sample %v to be rejected", sample)
+		}
+	}
+
+	s := newTestTapSubscriber(t, tap, "sample=0.5")
+	e := newTapEvent("k", "orders", `{}`)
+	matched := 0
+	for range 1000 {
+		if s.match(&e) {
+			matched++
+		}
+	}
+	if matched < 350 || matched > 650 {
+		t.Fatalf("expected about half of the events to match, got %v", matched)
+	}
+}
+
+func TestActionTap_Redact(t *testing.T) {
+	tap := newActionTap(config.Tap{MaxSubscribers: 2, BufferSize: 10, IncludeValues: true})
+	full := newTestTapSubscriber(t, tap, "")
+	redacted := newTestTapSubscriber(t, tap, "redact=true")
+
+	value := []byte(`{"name":"mug"}`)
+	e := couchbase.NewMutateEvent([]byte("k"), value, "orders", time.Time{}, 1, 2, 3, 4)
+	actions := []couchbase.CBActionDocument{couchbase.NewSetAction([]byte("k"), []byte("raw"))}
+	tap.publish(&e, actions)
+	// the record keeps its own copy of the event value
+	copy(value, `{"name":"cup"}`)
+
+	assertTapRecord(t, <-full.records,
+		`{"event":{"time":"0001-01-01T00:00:00Z","type":"mutation","key":"k","scope":"","collection":"orders",`+
+			`"value":{"name":"mug"},"cas":1,"seqNo":3,"vbId":2},"actions":[{"value":"raw","type":"Set","key":"k"}]}`)
+	assertTapRecord(t, <-redacted.records,
+		`{"event":{"time":"0001-01-01T00:00:00Z","type":"mutation","key":"k","scope":"","collection":"orders",`+
+			`"value":"\u003credacted 14 bytes\u003e","cas":1,"seqNo":3,"vbId":2},`+
+			`"actions":[{"value":"\u003credacted 3 bytes\u003e","type":"Set","key":"k"}]}`)
+}
+
+func assertTapRecord(t *testing.T, record tapRecord, expected string) {
+	t.Helper()
+
+	line, err := jsoniter.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, line)
+	}
+}
+
+func TestActionTap_Dropped(t *testing.T) {
+	tap := newActionTap(config.Tap{MaxSubscribers: 1, BufferSize: 1})
+	s := newTestTapSubscriber(t, tap, "")
+
+	for _, key := range []string{"a", "b", "c"} {
+		e := newTapEvent(key, "orders", `{}`)
+		tap.publish(&e, nil)
+	}
+
+	if record := <-s.records; record.Event.Key != "a" {
+		t.Fatalf("expected the first record to be kept, got %v", record.Event.Key)
+	}
+	if dropped := s.dropped.Load(); dropped != 2 {
+		t.Fatalf("expected 2 dropped records, got %v", dropped)
+	}
+}
+
+func TestActionTap_Serve(t *testing.T) {
+	tap := newActionTap(config.Tap{MaxSubscribers: 1, BufferSize: 1})
+	server := httptest.NewServer(http.HandlerFunc(tap.serveTap))
+	defer server.Close()
+	defer tap.close()
+
+	response, err := http.Get(server.URL + "?format=ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response %v %v", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	var s *tapSubscriber
+	tap.mu.RLock()
+	for subscriber := range tap.subscribers {
+		s = subscriber
+	}
+	tap.mu.RUnlock()
+	// records dropped before the next one is written are reported with it
+	s.dropped.Add(2)
+	e := newTapEvent("a", "orders", `{}`)
+	tap.publish(&e, nil)
+
+	line, err := bufio.NewReader(response.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record tapRecord
+	if err = jsoniter.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Event.Key != "a" || record.Dropped != 2 {
+		t.Fatalf("expected record a after 2 dropped ones, got %v %v", record.Event.Key, record.Dropped)
+	}
+
+	response, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the second subscriber to be rejected, got %v", response.StatusCode)
+	}
+}
@@ -0,0 +1,30 @@
+package dcpcouchbase
+
+import (
+	"fmt"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+// TypedMapperFunc receives the decoded mutation value, value is nil for deletions and expirations.
+type TypedMapperFunc[T any] func(ctx couchbase.EventContext, value *T) ([]couchbase.CBActionDocument, error)
+
+// TypedMapper decodes mutation values into T with couchbase.DefaultCodec,
+// decode errors are handled by `couchbase.mapperErrorPolicy`.
+func TypedMapper[T any](mapper TypedMapperFunc[T]) MapperE {
+	return TypedMapperWithCodec(couchbase.DefaultCodec, mapper)
+}
+
+func TypedMapperWithCodec[T any](codec couchbase.Codec, mapper TypedMapperFunc[T]) MapperE {
+	return func(ctx couchbase.EventContext) ([]couchbase.CBActionDocument, error) {
+		if !ctx.IsMutated {
+			return mapper(ctx, nil)
+		}
+
+		value := new(T)
+		if err := codec.Unmarshal(ctx.Value, value); err != nil {
+			return nil, fmt.Errorf("error while decode value of %s: %w", ctx.Key, err)
+		}
+		return mapper(ctx, value)
+	}
+}
@@ -0,0 +1,114 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+type typedProduct struct {
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+}
+
+// upperCodec decodes the name in upper case, to tell it apart from couchbase.DefaultCodec.
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	return couchbase.DefaultCodec.Marshal(v)
+}
+
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	if err := couchbase.DefaultCodec.Unmarshal(data, v); err != nil {
+		return err
+	}
+	v.(*typedProduct).Name = strings.ToUpper(v.(*typedProduct).Name)
+	return nil
+}
+
+type failingCodec struct{ upperCodec }
+
+func (failingCodec) Unmarshal([]byte, any) error {
+	return errors.New("codec failed")
+}
+
+func typedProductMapper(ctx couchbase.EventContext, value *typedProduct) ([]couchbase.CBActionDocument, error) {
+	if value == nil {
+		return []couchbase.CBActionDocument{couchbase.NewDeleteAction(ctx.Key)}, nil
+	}
+	action, err := couchbase.NewTypedSetAction(ctx.Key, value.Name)
+	return []couchbase.CBActionDocument{action}, err
+}
+
+func TestTypedMapper(t *testing.T) {
+	deletion := couchbase.NewDeleteEvent([]byte("p"), nil, "products", time.Time{}, 1, 2, 3, 4)
+
+	tests := []struct {
+		event   couchbase.Event
+		mapper  MapperE
+		name    string
+		wantErr string
+		want    couchbase.CbAction
+		source  string
+	}{
+		{
+			name:   "mutation is decoded with the default codec",
+			mapper: TypedMapper(typedProductMapper),
+			event:  newTapEvent("p", "products", `{"name":"mug","price":10}`),
+			want:   couchbase.Set,
+			source: `"mug"`,
+		},
+		{
+			name:   "mutation is decoded with a custom codec",
+			mapper: TypedMapperWithCodec(upperCodec{}, typedProductMapper),
+			event:  newTapEvent("p", "products", `{"name":"mug","price":10}`),
+			want:   couchbase.Set,
+			source: `"MUG"`,
+		},
+		{
+			name:    "invalid json is a decode error",
+			mapper:  TypedMapper(typedProductMapper),
+			event:   newTapEvent("p", "products", `{"name":`),
+			wantErr: "error while decode value of p: ",
+		},
+		{
+			name:    "type mismatch is a decode error",
+			mapper:  TypedMapper(typedProductMapper),
+			event:   newTapEvent("p", "products", `{"price":"ten"}`),
+			wantErr: "error while decode value of p: ",
+		},
+		{
+			name:    "custom codec error is a decode error",
+			mapper:  TypedMapperWithCodec(failingCodec{}, typedProductMapper),
+			event:   newTapEvent("p", "products", `{}`),
+			wantErr: "error while decode value of p: codec failed",
+		},
+		{
+			name:   "deletion gets a nil value without decoding",
+			mapper: TypedMapperWithCodec(failingCodec{}, typedProductMapper),
+			event:  deletion,
+			want:   couchbase.Delete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions, err := tt.mapper(couchbase.EventContext{Event: tt.event})
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) || actions != nil {
+					t.Fatalf("expected error %q without actions, got %v %v", tt.wantErr, actions, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(actions) != 1 || actions[0].Type != tt.want || string(actions[0].Source) != tt.source {
+				t.Fatalf("expected a %v action with %s, got %+v", tt.want, tt.source, actions)
+			}
+		})
+	}
+}
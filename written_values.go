@@ -0,0 +1,140 @@
+package dcpcouchbase
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+// resettable is mapper state which is dropped when a rebalance starts, another connector instance may write
+// the documents of the moved vbuckets meanwhile.
+type resettable interface {
+	reset()
+}
+
+// writtenValues remembers the last value a mapper wrote per key, so the next event of the key neither reads
+// a target document whose write is still in the batch nor a stale cached one.
+// A value is pending until all of its actions are written, it moves to the LRU once they succeed and is
+// forgotten when one of them fails, so the next event reads the target again.
+type writtenValues[V any] struct {
+	pending map[string]*pendingValue[V]
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int
+	mu      sync.Mutex
+}
+
+type pendingValue[V any] struct {
+	value     V
+	remaining int
+}
+
+type writtenEntry[V any] struct {
+	value V
+	key   string
+}
+
+func newWrittenValues[V any](size int) *writtenValues[V] {
+	return &writtenValues[V]{
+		pending: make(map[string]*pendingValue[V]),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		size:    size,
+	}
+}
+
+// get returns the value of the last write of key, pending or written.
+func (w *writtenValues[V]) get(key string) (V, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if pending, ok := w.pending[key]; ok {
+		return pending.value, true
+	}
+	if element, ok := w.entries[key]; ok {
+		w.lru.MoveToFront(element)
+		return element.Value.(*writtenEntry[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// track records value as pending until actions are written, nothing is recorded without actions.
+func (w *writtenValues[V]) track(key string, value V, actions []couchbase.CBActionDocument) {
+	if len(actions) == 0 {
+		return
+	}
+
+	pending := &pendingValue[V]{value: value, remaining: len(actions)}
+	w.mu.Lock()
+	w.pending[key] = pending
+	w.mu.Unlock()
+
+	for i := range actions {
+		actions[i].AddWrittenCallback(func(err error) {
+			w.complete(key, pending, err)
+		})
+	}
+}
+
+func (w *writtenValues[V]) complete(key string, pending *pendingValue[V], err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err != nil {
+		// later writes of key were computed from the failed one
+		delete(w.pending, key)
+		w.remove(key)
+		return
+	}
+
+	if w.pending[key] != pending {
+		// superseded by a later write or dropped by reset
+		return
+	}
+	if pending.remaining--; pending.remaining > 0 {
+		return
+	}
+	delete(w.pending, key)
+	w.put(key, pending.value)
+}
+
+// store records value as written unless a write of key is pending, e.g. a value read from the target.
+func (w *writtenValues[V]) store(key string, value V) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.pending[key]; !ok {
+		w.put(key, value)
+	}
+}
+
+func (w *writtenValues[V]) put(key string, value V) {
+	if element, ok := w.entries[key]; ok {
+		element.Value.(*writtenEntry[V]).value = value
+		w.lru.MoveToFront(element)
+		return
+	}
+
+	w.entries[key] = w.lru.PushFront(&writtenEntry[V]{key: key, value: value})
+	for w.lru.Len() > w.size {
+		w.remove(w.lru.Back().Value.(*writtenEntry[V]).key)
+	}
+}
+
+func (w *writtenValues[V]) remove(key string) {
+	if element, ok := w.entries[key]; ok {
+		w.lru.Remove(element)
+		delete(w.entries, key)
+	}
+}
+
+func (w *writtenValues[V]) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = make(map[string]*pendingValue[V])
+	w.entries = make(map[string]*list.Element)
+	w.lru.Init()
+}
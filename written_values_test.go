@@ -0,0 +1,75 @@
+package dcpcouchbase
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Trendyol/go-dcp-couchbase/couchbase"
+)
+
+func assertWritten(t *testing.T, w *writtenValues[string], key string, expected string, expectedOk bool) {
+	t.Helper()
+
+	value, ok := w.get(key)
+	if value != expected || ok != expectedOk {
+		t.Fatalf("expected %q %v for %s, got %q %v", expected, expectedOk, key, value, ok)
+	}
+}
+
+func TestWrittenValues(t *testing.T) {
+	w := newWrittenValues[string](1)
+
+	var callbacks []func(error)
+	for _, value := range []string{"v1", "v2"} {
+		actions := make([]couchbase.CBActionDocument, 2)
+		w.track("k", value, actions)
+		for range actions {
+			key, pending := "k", w.pending["k"]
+			callbacks = append(callbacks, func(err error) { w.complete(key, pending, err) })
+		}
+	}
+	assertWritten(t, w, "k", "v2", true)
+
+	// v1 is superseded, its writes do not overwrite v2
+	callbacks[0](nil)
+	callbacks[1](nil)
+	assertWritten(t, w, "k", "v2", true)
+
+	callbacks[2](nil)
+	if _, ok := w.entries["k"]; ok {
+		t.Fatal("expected v2 to stay pending until all of its actions are written")
+	}
+	callbacks[3](nil)
+	if len(w.pending) != 0 {
+		t.Fatalf("expected no pending values, got %v", w.pending)
+	}
+	assertWritten(t, w, "k", "v2", true)
+
+	// the lru keeps size entries
+	w.track("other", "o1", make([]couchbase.CBActionDocument, 1))
+	w.complete("other", w.pending["other"], nil)
+	assertWritten(t, w, "k", "", false)
+	assertWritten(t, w, "other", "o1", true)
+}
+
+func TestWrittenValues_Failure(t *testing.T) {
+	w := newWrittenValues[string](10)
+	w.track("k", "v1", make([]couchbase.CBActionDocument, 1))
+	w.complete("k", w.pending["k"], nil)
+
+	w.track("k", "v2", make([]couchbase.CBActionDocument, 1))
+	w.complete("k", w.pending["k"], errors.New("write failed"))
+	assertWritten(t, w, "k", "", false)
+}
+
+func TestWrittenValues_Reset(t *testing.T) {
+	w := newWrittenValues[string](10)
+	w.track("k", "v1", make([]couchbase.CBActionDocument, 1))
+	pending := w.pending["k"]
+	w.reset()
+	assertWritten(t, w, "k", "", false)
+
+	// a write completing after the reset is not remembered
+	w.complete("k", pending, nil)
+	assertWritten(t, w, "k", "", false)
+}